@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Borrow fees are charged for each day a short is held, as a share of its market value
+// at the annual borrow rate over a 360 day year.
+var BORROW_DAYS_PER_YEAR = 360.0
+
+// Annual borrow rates per symbol, overriding the default rate, and the symbols that are
+// hard to borrow; those are charged the hard-to-borrow rate unless they have a rate of
+// their own.
+type BorrowRates struct {
+	Rates        map[string]float64
+	HardToBorrow map[string]bool
+}
+
+type borrowRatesFile struct {
+	Rates        map[string]float64 `json:"rates"`
+	HardToBorrow []string           `json:"hard_to_borrow"`
+}
+
+var borrowRates = LoadBorrowRates(os.Getenv("BORROW_RATES_FILE"))
+
+// Return the borrow rates from the specified file (if any). The file looks like:
+//
+//	{"rates": {"TSLA": 0.005}, "hard_to_borrow": ["GME", "AMC"]}
+func LoadBorrowRates(path string) *BorrowRates {
+	r := &BorrowRates{
+		Rates:        make(map[string]float64),
+		HardToBorrow: make(map[string]bool),
+	}
+
+	if path == "" {
+		return r
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Errorf("Unable to read borrow rates file %s: %v", path, err)
+		return r
+	}
+
+	var contents borrowRatesFile
+	if err := json.Unmarshal(raw, &contents); err != nil {
+		log.Errorf("Unable to parse borrow rates file %s: %v", path, err)
+		return r
+	}
+
+	for symbol, rate := range contents.Rates {
+		r.Rates[strings.ToUpper(symbol)] = rate
+	}
+	for i := range contents.HardToBorrow {
+		r.HardToBorrow[strings.ToUpper(contents.HardToBorrow[i])] = true
+	}
+
+	return r
+}
+
+// Returns the annual rate for borrowing shares of the symbol.
+func (r *BorrowRates) Rate(symbol string) float64 {
+	if rate, ok := r.Rates[symbol]; ok {
+		return rate
+	}
+
+	if r.HardToBorrow[symbol] {
+		return settings.HardToBorrowRate
+	}
+
+	return settings.BorrowRate
+}
+
+// Charge the borrow fees owed on each of the user's shorts for the days since they were
+// last charged, through the given day, recording each in the ledger. Shorts from before
+// borrow fees were charged start accruing from the given day, and shorts without a
+// quote yet are left to be charged on a later run. Charging is safe to repeat, as
+// every lot keeps track of the day it has been charged through.
+func (u *User) accrueBorrowFees(through time.Time, session string) (charged float64) {
+	for i := range u.Portfolio {
+		asset := u.Portfolio[i]
+		if asset.Type != "short" {
+			continue
+		}
+
+		last, err := time.ParseInLocation(CALENDAR_DATE_FORMAT, asset.FeesThrough, calendar.Location)
+		if err != nil {
+			asset.FeesThrough = through.Format(CALENDAR_DATE_FORMAT)
+			continue
+		}
+
+		days := math.Round(through.Sub(last).Hours() / 24)
+		if days < 1 {
+			continue
+		}
+
+		// Fees are charged on the value of the shares borrowed, so wait for a quote.
+		quote, ok := quotes.GetCurrent(asset.Symbol)
+		if !ok || quote.ReceivedAt.IsZero() || quote.LastPrice == 0 {
+			continue
+		}
+
+		fee := quote.LastPrice * asset.Quantity * borrowRates.Rate(asset.Symbol) / BORROW_DAYS_PER_YEAR * days
+		asset.FeesThrough = through.Format(CALENDAR_DATE_FORMAT)
+		if fee == 0 {
+			continue
+		}
+
+		asset.BorrowFees = asset.BorrowFees + fee
+		u.Funds = u.Funds - fee
+		u.realize(asset.Symbol, -fee, time.Now())
+		u.record(&LedgerEntry{
+			Event:    LedgerBorrowFee,
+			LotID:    asset.ID,
+			Type:     asset.Type,
+			Symbol:   asset.Symbol,
+			Quantity: asset.Quantity,
+			Price:    quote.LastPrice,
+			Basis:    asset.CostBasis,
+			Amount:   -fee,
+			Gain:     -fee,
+			Session:  session,
+		})
+		charged = charged + fee
+	}
+
+	return charged
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBorrowFeesWaitForAQuote(t *testing.T) {
+	defer func(saved Settings) { *settings = saved }(*settings)
+	settings.BorrowRate = 0.036
+
+	savedQuotes := quotes
+	t.Cleanup(func() { quotes = savedQuotes })
+
+	feed, err := NewReplayFeed(writeReplayScript(t, "quotes.json", `[
+		{"short_name": "AAPL", "lp": 100},
+		{"short_name": "MSFT", "lp": 200}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Step()
+	quotes = feed
+
+	through := time.Date(2026, 10, 14, 0, 0, 0, 0, calendar.Location)
+	user := &User{
+		UserID: "U1",
+		Portfolio: []*Asset{
+			{ID: "s1", Type: "short", Symbol: "AAPL", CostBasis: 50, Quantity: 10, FeesThrough: "2026-10-12"},
+			{ID: "s2", Type: "short", Symbol: "MSFT", CostBasis: 50, Quantity: 10, FeesThrough: "2026-10-12"},
+		},
+	}
+
+	// Only AAPL has a quote; MSFT is left alone rather than charged at its cost basis.
+	if charged := user.accrueBorrowFees(through, SessionClosed); math.Abs(charged-0.2) > 0.000001 {
+		t.Errorf("charged %v, want 0.2 on AAPL only", charged)
+	}
+	if fees := user.Portfolio[1]; fees.FeesThrough != "2026-10-12" || fees.BorrowFees != 0 {
+		t.Errorf("MSFT was charged %v through %s without a quote", fees.BorrowFees, fees.FeesThrough)
+	}
+
+	// Once the quote arrives, the days missed are charged at its price.
+	feed.Step()
+	if charged := user.accrueBorrowFees(through, SessionClosed); math.Abs(charged-0.4) > 0.000001 {
+		t.Errorf("charged %v, want 0.4 on MSFT", charged)
+	}
+	for _, asset := range user.Portfolio {
+		if asset.FeesThrough != "2026-10-14" {
+			t.Errorf("%s charged through %s, want 2026-10-14", asset.Symbol, asset.FeesThrough)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+	_ "time/tzdata"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Market sessions, named to match the TradingView `current_session` values.
+const (
+	SessionPreMarket  = "pre_market"
+	SessionMarket     = "market"
+	SessionPostMarket = "post_market"
+	SessionClosed     = "closed"
+)
+
+var CALENDAR_DATE_FORMAT = "2006-01-02"
+
+// A MarketCalendar knows when the exchange is open. Holidays and early closes follow
+// the NYSE rules, and can be added to or overridden from a JSON file.
+type MarketCalendar struct {
+	Location    *time.Location
+	PreOpen     time.Duration
+	Open        time.Duration
+	Close       time.Duration
+	EarlyClose  time.Duration
+	PostClose   time.Duration
+	Holidays    map[string]string
+	EarlyCloses map[string]time.Duration
+}
+
+type marketCalendarFile struct {
+	Holidays    map[string]string `json:"holidays"`
+	EarlyCloses map[string]string `json:"early_closes"`
+}
+
+var calendar = LoadMarketCalendar(os.Getenv("MARKET_CALENDAR_FILE"))
+
+// Return the NYSE calendar, with any holidays and early closes from the specified file
+// (if any) layered on top. The file looks like:
+//
+//	{"holidays": {"2026-12-31": "Exchange closure"}, "early_closes": {"2026-12-30": "13:00"}}
+func LoadMarketCalendar(path string) *MarketCalendar {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		panic(err)
+	}
+
+	c := &MarketCalendar{
+		Location:    location,
+		PreOpen:     4 * time.Hour,
+		Open:        9*time.Hour + 30*time.Minute,
+		Close:       16 * time.Hour,
+		EarlyClose:  13 * time.Hour,
+		PostClose:   20 * time.Hour,
+		Holidays:    make(map[string]string),
+		EarlyCloses: make(map[string]time.Duration),
+	}
+
+	if path == "" {
+		return c
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Errorf("Unable to read market calendar file %s: %v", path, err)
+		return c
+	}
+
+	var contents marketCalendarFile
+	if err := json.Unmarshal(raw, &contents); err != nil {
+		log.Errorf("Unable to parse market calendar file %s: %v", path, err)
+		return c
+	}
+
+	for date, name := range contents.Holidays {
+		c.Holidays[date] = name
+	}
+	for date, close := range contents.EarlyCloses {
+		parsed, err := time.Parse("15:04", close)
+		if err != nil {
+			log.Errorf("Invalid early close time for %s in %s: %v", date, path, err)
+			continue
+		}
+		c.EarlyCloses[date] = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+
+	return c
+}
+
+// Returns the name of the holiday the exchange is closed for on the given day, if any.
+func (c *MarketCalendar) Holiday(day time.Time) (name string, ok bool) {
+	day = day.In(c.Location)
+	if name, ok := c.Holidays[day.Format(CALENDAR_DATE_FORMAT)]; ok {
+		return name, true
+	}
+
+	return nyseHoliday(day)
+}
+
+// Returns whether the exchange trades at all on the given day.
+func (c *MarketCalendar) IsTradingDay(day time.Time) bool {
+	day = day.In(c.Location)
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false
+	}
+
+	_, holiday := c.Holiday(day)
+	return !holiday
+}
+
+// Returns the time the regular session closes on the given day.
+func (c *MarketCalendar) CloseOn(day time.Time) time.Time {
+	day = day.In(c.Location)
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, c.Location)
+
+	if close, ok := c.EarlyCloses[day.Format(CALENDAR_DATE_FORMAT)]; ok {
+		return midnight.Add(close)
+	}
+	if nyseEarlyClose(day) {
+		return midnight.Add(c.EarlyClose)
+	}
+
+	return midnight.Add(c.Close)
+}
+
+// Returns the trading day whose regular session the given time belongs to; the same day
+// until its session closes, and the next trading day after that.
+func (c *MarketCalendar) TradingDate(t time.Time) time.Time {
+	t = t.In(c.Location)
+	if c.IsTradingDay(t) && t.Before(c.CloseOn(t)) {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.Location)
+	}
+
+	open := c.NextOpen(t)
+	return time.Date(open.Year(), open.Month(), open.Day(), 0, 0, 0, 0, c.Location)
+}
+
+// Returns the session the exchange is in at the given time.
+func (c *MarketCalendar) Session(t time.Time) string {
+	t = t.In(c.Location)
+	if !c.IsTradingDay(t) {
+		return SessionClosed
+	}
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.Location)
+	close := c.CloseOn(t)
+	post := close.Add(c.PostClose - c.Close)
+
+	switch {
+	case t.Before(midnight.Add(c.PreOpen)):
+		return SessionClosed
+	case t.Before(midnight.Add(c.Open)):
+		return SessionPreMarket
+	case t.Before(close):
+		return SessionMarket
+	case t.Before(post):
+		return SessionPostMarket
+	}
+
+	return SessionClosed
+}
+
+// Returns whether players may trade at the given time; during the regular session, or
+// during pre and post market if extended hours trading is enabled.
+func (c *MarketCalendar) CanTrade(t time.Time) bool {
+	switch c.Session(t) {
+	case SessionMarket:
+		return true
+	case SessionPreMarket, SessionPostMarket:
+		return settings.ExtendedHours
+	}
+
+	return false
+}
+
+// Returns the time players stop being able to trade on the given trading day; the close
+// of post market with extended hours trading, or of the regular session without.
+func (c *MarketCalendar) TradingEndsOn(day time.Time) time.Time {
+	close := c.CloseOn(day)
+	if !settings.ExtendedHours {
+		return close
+	}
+
+	return close.Add(c.PostClose - c.Close)
+}
+
+// Returns the next time players stop being able to trade after the given time.
+func (c *MarketCalendar) NextTradingEnd(t time.Time) time.Time {
+	t = t.In(c.Location)
+	for day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.Location); ; day = day.AddDate(0, 0, 1) {
+		if ends := c.TradingEndsOn(day); c.IsTradingDay(day) && ends.After(t) {
+			return ends
+		}
+	}
+}
+
+// Returns the next time the regular session opens after the given time.
+func (c *MarketCalendar) NextOpen(t time.Time) time.Time {
+	t = t.In(c.Location)
+	for day := t; ; day = day.AddDate(0, 0, 1) {
+		if !c.IsTradingDay(day) {
+			continue
+		}
+
+		open := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, c.Location).Add(c.Open)
+		if open.After(t) {
+			return open
+		}
+	}
+}
+
+// Returns the next time players will be able to trade after the given time.
+func (c *MarketCalendar) NextTradingTime(t time.Time) time.Time {
+	open := c.NextOpen(t)
+	if !settings.ExtendedHours {
+		return open
+	}
+
+	t = t.In(c.Location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.Location)
+	for day := midnight; day.Before(open); day = day.AddDate(0, 0, 1) {
+		if !c.IsTradingDay(day) {
+			continue
+		}
+
+		pre := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, c.Location).Add(c.PreOpen)
+		if pre.After(t) {
+			return pre
+		}
+	}
+
+	return open
+}
+
+// Format a time for players, in exchange time.
+func (c *MarketCalendar) Format(t time.Time) string {
+	return t.In(c.Location).Format("Monday, Jan 2 at 3:04 PM MST")
+}
+
+// Returns the NYSE holiday falling on the given day, if any.
+func nyseHoliday(day time.Time) (name string, ok bool) {
+	year := day.Year()
+	date := day.Format(CALENDAR_DATE_FORMAT)
+
+	// The NYSE doesn't close on the Friday before a New Year's Day that falls on a
+	// Saturday, as that Friday ends the previous year.
+	holidays := map[string]string{
+		observed(time.Date(year, time.January, 1, 0, 0, 0, 0, day.Location()), false):  "New Year's Day",
+		nthWeekday(year, time.January, time.Monday, 3, day.Location()):                 "Martin Luther King, Jr. Day",
+		nthWeekday(year, time.February, time.Monday, 3, day.Location()):                "Washington's Birthday",
+		easter(year, day.Location()).AddDate(0, 0, -2).Format(CALENDAR_DATE_FORMAT):    "Good Friday",
+		lastWeekday(year, time.May, time.Monday, day.Location()):                       "Memorial Day",
+		observed(time.Date(year, time.July, 4, 0, 0, 0, 0, day.Location()), true):      "Independence Day",
+		nthWeekday(year, time.September, time.Monday, 1, day.Location()):               "Labor Day",
+		nthWeekday(year, time.November, time.Thursday, 4, day.Location()):              "Thanksgiving Day",
+		observed(time.Date(year, time.December, 25, 0, 0, 0, 0, day.Location()), true): "Christmas Day",
+	}
+	if year >= 2022 {
+		holidays[observed(time.Date(year, time.June, 19, 0, 0, 0, 0, day.Location()), true)] = "Juneteenth"
+	}
+
+	name, ok = holidays[date]
+	return name, ok
+}
+
+// Returns whether the NYSE closes early on the given day; the day before Independence
+// Day, the day after Thanksgiving and Christmas Eve, when they are trading days.
+func nyseEarlyClose(day time.Time) bool {
+	year := day.Year()
+	date := day.Format(CALENDAR_DATE_FORMAT)
+
+	if _, holiday := nyseHoliday(day); holiday || day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false
+	}
+
+	thanksgiving, _ := time.ParseInLocation(CALENDAR_DATE_FORMAT, nthWeekday(year, time.November, time.Thursday, 4, day.Location()), day.Location())
+
+	switch date {
+	case fmt.Sprintf("%d-07-03", year), fmt.Sprintf("%d-12-24", year):
+		return true
+	case thanksgiving.AddDate(0, 0, 1).Format(CALENDAR_DATE_FORMAT):
+		return true
+	}
+
+	return false
+}
+
+// Returns the date a fixed holiday is observed on. Holidays on a Sunday move to the
+// Monday; holidays on a Saturday move to the Friday, if allowed.
+func observed(day time.Time, saturdayToFriday bool) string {
+	switch day.Weekday() {
+	case time.Sunday:
+		day = day.AddDate(0, 0, 1)
+	case time.Saturday:
+		if saturdayToFriday {
+			day = day.AddDate(0, 0, -1)
+		}
+	}
+
+	return day.Format(CALENDAR_DATE_FORMAT)
+}
+
+// Returns the date of the nth weekday of the month.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int, location *time.Location) string {
+	day := time.Date(year, month, 1, 0, 0, 0, 0, location)
+	for day.Weekday() != weekday {
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return day.AddDate(0, 0, 7*(n-1)).Format(CALENDAR_DATE_FORMAT)
+}
+
+// Returns the date of the last weekday of the month.
+func lastWeekday(year int, month time.Month, weekday time.Weekday, location *time.Location) string {
+	day := time.Date(year, month+1, 1, 0, 0, 0, 0, location).AddDate(0, 0, -1)
+	for day.Weekday() != weekday {
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return day.Format(CALENDAR_DATE_FORMAT)
+}
+
+// Returns Easter Sunday of the year, using the anonymous Gregorian algorithm.
+func easter(year int, location *time.Location) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := ((h + l - 7*m + 114) % 31) + 1
+
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, location)
+}
@@ -40,21 +40,113 @@ func (c *Command) Say(msg string, formatting ...interface{}) {
 	)
 }
 
+// Returns why players can't currently trade.
+func closedReason(now time.Time) string {
+	if holiday, ok := calendar.Holiday(now); ok {
+		return fmt.Sprintf("the market is closed for %s", holiday)
+	} else if session := calendar.Session(now); session == SessionPreMarket || session == SessionPostMarket {
+		return "extended hours trading is disabled"
+	}
+
+	return "the market is closed right now"
+}
+
+// Returns whether players can currently trade. If not, lets the user know when they
+// next can.
+func (c *Command) CheckMarketOpen() bool {
+	now := time.Now()
+	if calendar.CanTrade(now) {
+		return true
+	}
+
+	c.Say("<@%s>, %s. You can trade again %s.", c.User.UserID, closedReason(now), calendar.Format(calendar.NextTradingTime(now)))
+	return false
+}
+
+// Decide when a market order can be placed; returns whether it should be queued as a
+// market-on-open order (when asked for with a trailing `open` outside of the regular
+// session), and whether it can be placed at all; if not, the user is told when they
+// can next trade.
+func (c *Command) MarketOrderTiming() (onOpen bool, ok bool) {
+	now := time.Now()
+	if c.HasFlag("open") && calendar.Session(now) != SessionMarket {
+		return true, true
+	}
+
+	if calendar.CanTrade(now) {
+		return false, true
+	}
+
+	c.Say("<@%s>, %s. You can trade again %s, or add `open` to the end of your order to have it placed when the market opens.", c.User.UserID, closedReason(now), calendar.Format(calendar.NextTradingTime(now)))
+	return false, false
+}
+
+// Returns whether the flag was passed as any of the arguments.
+func (c *Command) HasFlag(flag string) bool {
+	for i := range c.Args {
+		if strings.EqualFold(c.Args[i], flag) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *Command) GetArgAsInteger(position int) (value int64, err error) {
 	if len(c.Args)-1 < position {
 		return 0, fmt.Errorf("missing arguments")
 	}
 
-	if value, err = strconv.ParseInt(c.Args[position], 10, 32); err != nil {
+	if value, err = strconv.ParseInt(c.Args[position], 10, 64); err != nil {
 		re := regexp.MustCompile(`^<tel:([0-9]+)\|[0-9]+>$`)
 		parsed := re.FindStringSubmatch(c.Args[position])
 		if len(parsed) == 2 {
-			return strconv.ParseInt(parsed[1], 10, 32)
+			return strconv.ParseInt(parsed[1], 10, 64)
 		}
 	}
 	return value, err
 }
 
+// Parse the argument as a number of shares; fractional shares may be given up to the
+// number of decimals players trade in.
+func (c *Command) GetArgAsQuantity(position int) (value float64, err error) {
+	if len(c.Args)-1 < position {
+		return 0, fmt.Errorf("missing arguments")
+	}
+
+	if value, err = strconv.ParseFloat(c.Args[position], 64); err != nil {
+		var whole int64
+		if whole, err = c.GetArgAsInteger(position); err != nil {
+			return 0, err
+		}
+		value = float64(whole)
+	}
+
+	if value <= 0 || math.IsInf(value, 0) || roundShares(value) != value {
+		return 0, fmt.Errorf("invalid quantity of shares")
+	}
+
+	return value, nil
+}
+
+// Parse the argument as a dollar amount to trade shares for, e.g. `$500`.
+func (c *Command) GetArgAsNotional(position int) (value float64, err error) {
+	if len(c.Args)-1 < position {
+		return 0, fmt.Errorf("missing arguments")
+	}
+
+	if !strings.HasPrefix(c.Args[position], "$") {
+		return 0, fmt.Errorf("unable to parse as dollar amount")
+	}
+
+	value, err = strconv.ParseFloat(strings.ReplaceAll(c.Args[position][1:], ",", ""), 64)
+	if err == nil && (value <= 0 || math.IsInf(value, 0)) {
+		err = fmt.Errorf("invalid dollar amount")
+	}
+
+	return value, err
+}
+
 func (c *Command) GetArgAsString(position int) (value string, err error) {
 	if len(c.Args)-1 < position {
 		return "", fmt.Errorf("missing arguments")
@@ -68,7 +160,7 @@ func (c *Command) GetArgAsStockSymbol(position int) (symbol string, err error) {
 		return "", fmt.Errorf("missing arguments")
 	}
 
-	re := regexp.MustCompile(`^\$?([a-zA-Z]+)$`)
+	re := regexp.MustCompile(`^\$?([a-zA-Z]+(?:\.[a-zA-Z]+)?)$`)
 	parsed := re.FindStringSubmatch(c.Args[position])
 	if len(parsed) == 2 {
 		return strings.ToUpper(parsed[1]), nil
@@ -92,6 +184,90 @@ func (c *Command) GetArgAsFloat(position int) (value float64, err error) {
 	return value, err
 }
 
+// Parse the argument as either a dollar amount, or a percentage if suffixed with `%`.
+func (c *Command) GetArgAsAmountOrPercent(position int) (value float64, percent bool, err error) {
+	if len(c.Args)-1 < position {
+		return 0, false, fmt.Errorf("missing arguments")
+	}
+
+	if strings.HasSuffix(c.Args[position], "%") {
+		value, err = strconv.ParseFloat(strings.TrimSuffix(c.Args[position], "%"), 64)
+		return value, true, err
+	}
+
+	value, err = c.GetArgAsFloat(position)
+	return value, false, err
+}
+
+// Parse the optional time-in-force of an order from the argument; one of `day`, `gtc`,
+// `ioc`, or `gtd` followed by the date the order is good until (which can also be
+// given on its own). Orders are good until cancelled if left out.
+func (c *Command) GetArgAsTimeInForce(position int) (tif string, expires string, err error) {
+	if len(c.Args)-1 < position {
+		return TimeInForceGTC, "", nil
+	}
+
+	now := time.Now()
+	switch tif = strings.ToLower(c.Args[position]); tif {
+	case TimeInForceGTC, TimeInForceIOC:
+		return tif, "", nil
+	case TimeInForceDay:
+		return tif, calendar.TradingDate(now).Format(CALENDAR_DATE_FORMAT), nil
+	case TimeInForceGTD:
+		position = position + 1
+		if len(c.Args)-1 < position {
+			return "", "", fmt.Errorf("missing arguments")
+		}
+	}
+
+	date, err := time.ParseInLocation(CALENDAR_DATE_FORMAT, c.Args[position], calendar.Location)
+	if err != nil {
+		return "", "", err
+	}
+	if date.Before(calendar.TradingDate(now)) {
+		return "", "", fmt.Errorf("good-till-date is in the past")
+	}
+
+	return TimeInForceGTD, date.Format(CALENDAR_DATE_FORMAT), nil
+}
+
+// Parse the quantity of a market order from the argument; either a number of shares, or
+// a dollar amount (`$500`) to trade shares for at the latest price.
+func (c *Command) GetArgAsShares(position int) (quantity float64, amount float64, err error) {
+	if amount, err = c.GetArgAsNotional(position); err == nil {
+		return 0, amount, nil
+	}
+
+	quantity, err = c.GetArgAsQuantity(position)
+	return quantity, 0, err
+}
+
+// Trade the quantity of shares or, given a dollar amount instead, as many shares as the
+// amount gets at the latest market price for the side.
+func (c *Command) TradeShares(side string, symbol string, quantity float64, amount float64, trade func(quantity float64)) {
+	if amount == 0 {
+		trade(quantity)
+		return
+	}
+
+	quotes.GetQuote(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
+		if quote.Symbol != symbol || quote.LastPrice == 0 {
+			c.Say("<@%s> I was unable to find that stock; wanna try that again?", c.User.UserID)
+			return true
+		}
+
+		price := marketPrice(side, quote)
+		quantity := floorShares(amount / price)
+		if quantity <= 0 {
+			c.Say("<@%s>, $%.2f doesn't get you a single share of %s at $%.2f.", c.User.UserID, amount, symbol, price)
+			return true
+		}
+
+		trade(quantity)
+		return true
+	})
+}
+
 func (c *Command) GetOptionalUserFromArg(position int) (user *User) {
 	if len(c.Args)-1 < position {
 		return c.User
@@ -119,38 +295,57 @@ func (c *Command) CommandHelp() {
 
 	switch strings.ToLower(topic) {
 	case "funds":
-		response = "*!funds {@username}*\nSee your available funds. Optionally specify a target user to see their available funds. You can use `!f` as a shorthand alias to this command."
+		response = "*!funds {@username}*\nSee your available funds; when trading on margin, also your buying power, equity, and the equity you need to keep to avoid a margin call. Optionally specify a target user to see their available funds. You can use `!f` as a shorthand alias to this command."
 	case "portfolio":
-		response = "*!portfolio {@username}*\nSee your portfolio. Optionally specify a target user to see their portfolio. You can use `!p` as a shorthand alias to this command."
+		response = "*!portfolio {@username}*\nSee your portfolio, lot by lot, along with the lot IDs you can use to sell or cover a specific lot, and the borrow fees charged on your shorts so far. Optionally specify a target user to see their portfolio. You can use `!p` as a shorthand alias to this command."
 	case "buy":
-		response = "*!buy [quantity] [symbol]*\nPurchase the specified amount of shares in the specified stock, at the latest market price."
+		response = "*!buy [quantity|$amount|max] [symbol] {open}*\nPurchase the specified amount of shares in the specified stock, at the latest market price. Fractional shares can be bought, e.g. `!buy 0.25 BRK.A`; give a dollar amount instead (`!buy $500 AMZN`) to buy as many shares as that gets you at the latest price, or `max` to spend all your available funds. Add `open` to queue the order until the market next opens, when the market is closed."
 	case "sell":
-		response = "*!sell [quantity] [symbol] {price paid}*\nSell the specified amount of shares in the the specified stock, at the latest market price. Optionally specify the price paid to make a sale using shares that were bought at that price point."
+		response = "*!sell [quantity|$amount] [symbol] {price paid|lot [id]} {open}*\nSell the specified amount of shares in the the specified stock, at the latest market price, or as many as make up a dollar amount (`$500`). Optionally specify the price paid to make a sale using shares that were bought at that price point, or `lot` and a lot ID from `!portfolio` to sell from that lot; otherwise shares are sold according to your lot relief method (see `!help settings`). Add `open` to queue the order until the market next opens, when the market is closed; the shares are reserved for it until then."
 	case "short":
-		response = "*!short [quantity] [symbol]*\nShort the specified amount of shares in the specified stock, at the latest market price."
+		response = "*!short [quantity|$amount] [symbol] {open}*\nShort the specified amount of shares in the specified stock, at the latest market price, or as many as make up a dollar amount (`$500`). Borrow fees are charged on the value of your shorts after every close, for as long as you hold them. Add `open` to queue the order until the market next opens, when the market is closed."
 	case "cover":
-		response = "*!cover [quantity] [symbol] {price paid}*\nCover the specified amount of shares in the the specified stock, at the latest market price. Optionally specify the price paid to cover shares that were shorted at that price point."
+		response = "*!cover [quantity|$amount] [symbol] {price paid|lot [id]} {open}*\nCover the specified amount of shares in the the specified stock, at the latest market price, or as many as make up a dollar amount (`$500`). Optionally specify the price paid to cover shares that were shorted at that price point, or `lot` and a lot ID from `!portfolio` to cover that lot; otherwise shares are covered according to your lot relief method (see `!help settings`). Add `open` to queue the order until the market next opens, when the market is closed; the shares are reserved for it until then."
 	case "orders":
-		response = "*!orders {@username}*\nSee your limit orders. Optionally specify a target user to see their pending limit orders. You can use `!o` as a shorthand alias to this command."
+		response = "*!orders {@username} {all}*\nSee your pending orders, along with their IDs. Optionally specify a target user to see their pending orders, or add `all` to include recently filled, cancelled and expired orders. You can use `!o` as a shorthand alias to this command."
 	case "limit":
-		response = "*!limit [type] [quantity] [symbol] [price target]*\nCreate a limit order of the specified type (buy/sell/cover) for the specified amount of shares. When the price target is met, then your order will be executed. Limit orders are good until cancelled."
+		response = "*!limit [type] [quantity] [symbol] [price target] [day|gtc|ioc|gtd date]*\nCreate a limit order of the specified type (buy/sell/cover) for the specified amount of shares. When the price target is met, then your order will be executed. Sell and cover orders reserve the shares they close until they are filled or cancelled, so you can't sell or cover those shares in the meantime. Add `day` to the end to have the order expire at the close of the market, `gtd YYYY-MM-DD` to have it expire at the close on that date, or `ioc` to have it cancelled if it can't be filled immediately; otherwise limit orders are good until cancelled."
+	case "stop":
+		response = "*!stop [type] [quantity] [symbol] [stop price] [day|gtc|gtd date]*\nCreate a stop order of the specified type (buy/sell/cover) for the specified amount of shares. When the price moves past the stop price against you (falls to it for a sell, rises to it for a buy or cover), your order will be executed at the market price. Funds to cover buy and cover orders at the stop price are held until the order is finalized, or cancelled. Add `day` to the end to have the order expire at the close of the market, or `gtd YYYY-MM-DD` to have it expire at the close on that date; otherwise it is good until cancelled."
+	case "stoplimit":
+		response = "*!stoplimit [type] [quantity] [symbol] [stop price] [limit price] [day|gtc|gtd date]*\nCreate a stop-limit order of the specified type (buy/sell/cover) for the specified amount of shares. When the price moves past the stop price against you, a limit order at the limit price will be placed in its place. Funds to cover buy and cover orders at the limit price are held until the order is finalized, or cancelled. Add `day` to the end to have the order expire at the close of the market, or `gtd YYYY-MM-DD` to have it expire at the close on that date; otherwise it is good until cancelled."
+	case "trail":
+		response = "*!trail sell [quantity] [symbol] [trail] [day|gtc|gtd date]*\nCreate a trailing stop order to sell the specified amount of shares. The trail is either a dollar amount (`5`) or a percentage (`5%`); the stop follows the highest price seen since the order was placed, and once the price falls back from that high by the trail, your shares will be sold at the market price. Add `day` to the end to have the order expire at the close of the market, or `gtd YYYY-MM-DD` to have it expire at the close on that date; otherwise it is good until cancelled."
+	case "bracket":
+		response = "*!bracket {buy|short} [quantity] [symbol] [entry price] target [price] stop [price] {day|gtc|gtd date}*\nCreate a limit order to buy (or short) the specified amount of shares at the entry price. Once filled, a limit order to take profit at the target price and a stop order to stop losses at the stop price are placed; when either of them is filled, the other is cancelled. If you close part of the position yourself in the meantime, they only close what is left of it."
+	case "amend":
+		response = "*!amend [id] {price [price]} {qty [quantity]}*\nChange the price and/or quantity of a pending order in place, by its ID as shown in `!orders`. Limit orders can also be given as `[type] [quantity] [symbol] [price target]`, e.g. `!amend buy 50 AAPL 150 price 148`. Funds held for the order are adjusted to match; amendments you don't have the funds for are rejected, as are prices that would put the take-profit and stop-loss of a bracket on the wrong side of its entry or each other."
 	case "cancel":
-		response = "*!cancel [type] [quantity] [symbol] [price target]*\nCancel a limit order placed - arguments must match a limit order you previously created."
+		response = "*!cancel [id]*\nCancel a pending order by its ID, as shown in `!orders`. Any funds held for the order are returned to you."
 	case "liquidate":
 		response = "*!liquidate*\nSell and cover all your shares at the current market price. Will also cancel any limit orders you have in place."
 	case "bankruptcy":
 		response = "*!bankruptcy*\nFile for bankruptcy and reset your stonk market account."
 	case "leaderboard":
-		response = "*!leaderboard*\nShow the current leaderboard of all stonk market players. You can use `!l` as a shorthand alias to this command."
+		response = "*!leaderboard {networth|pnl}*\nShow the current leaderboard of all stonk market players, ranked by net worth. Optionally specify `pnl` to rank by realized profit and loss instead. You can use `!l` as a shorthand alias to this command."
+	case "pnl":
+		response = "*!pnl {@username} {period}*\nSee your realized and unrealized profit and loss. Optionally specify a target user to see theirs, and a period of `today`, `week`, `month`, `year` or `all` (default) to limit the realized gains to."
+	case "settings":
+		response = "*!settings {lots fifo|lifo|hifo|average}*\nSee your settings, or change them. `lots` picks which shares are sold or covered first when you only close part of a position: the oldest first (`fifo`, the default), the newest first (`lifo`), those with the highest price paid first (`hifo`), or `average` to close at the average price paid across the position."
+	case "history":
+		response = "*!history {@username} {symbol} {count}*\nSee your most recent transactions. Optionally specify a target user to see their transactions, a symbol to only see transactions in that stock, and how many transactions to show (defaults to 10)."
+	case "action":
+		response = "*!action split [symbol] [ratio]* or *!action dividend [symbol] [amount]*\nAdmins only. Apply a stock split (e.g. `4:1`, or `1:10` for a reverse split) or a cash dividend per share to everyone holding the stock, taking effect from the next session. Can only be used while the market is closed to trading. Lots and pending orders are adjusted to match a split, with fractional shares paid out in cash; dividends are paid on longs and owed on shorts."
 	default:
-		response = "Welcome to the Stonks Game - use `!help <topic>` to get more information. Available topics are: `funds`, `portfolio`, `buy`, `sell`, `short`, `cover`, `orders`, `limit`, `cancel`, `liquidate`, `bankruptcy`, `leaderboard`."
+		response = "Welcome to the Stonks Game - use `!help <topic>` to get more information. Available topics are: `funds`, `portfolio`, `buy`, `sell`, `short`, `cover`, `orders`, `limit`, `stop`, `stoplimit`, `trail`, `bracket`, `amend`, `cancel`, `liquidate`, `bankruptcy`, `leaderboard`, `history`, `pnl`, `settings`, `action`."
 	}
 
 	c.Say(response)
 }
 
 /* ***********************************************************************************
- * Funds - get the available funds of the initiator, or specified person.
+ * Funds - get the available funds of the initiator, or specified person. When trading
+ *         on margin, their buying power, equity and maintenance requirement as well.
  *
  * Syntax: [!funds|!f] [@mention:optional]
  */
@@ -158,6 +353,11 @@ func (c *Command) CommandF() { c.CommandFunds() }
 func (c *Command) CommandFunds() {
 	user := c.GetOptionalUserFromArg(0)
 
+	if settings.Margin {
+		c.Say("<@%s> has $%.2f in funds, and $%.2f of buying power on margin. Their equity is $%.2f, against a maintenance requirement of $%.2f.", user.UserID, user.Funds, user.BuyingPower(), user.Equity(), user.MaintenanceRequirement())
+		return
+	}
+
 	c.Say("<@%s> has $%.2f available for investing.", user.UserID, user.Funds)
 }
 
@@ -181,16 +381,17 @@ func (c *Command) CommandPortfolio() {
 	}
 
 	portfolio := []string{
-		fmt.Sprintf("%5s | %8s | %8s | %12s | %12s | %12s | %12s", "Type", "Symbol", "Qty", "Price Paid", "Last Price", "Curr Value", "Gain"),
+		fmt.Sprintf("%4s | %5s | %8s | %8s | %12s | %12s | %12s | %12s | %12s", "Lot", "Type", "Symbol", "Qty", "Price Paid", "Last Price", "Curr Value", "Gain", "Borrow Fees"),
 	}
 
 	var gains float64
 	var total float64
+	var fees float64
 	var positions int
 
 	for i := range user.Portfolio {
 		asset := user.Portfolio[i]
-		quote, ok := tradingview.GetCurrent(asset.Symbol)
+		quote, ok := quotes.GetCurrent(asset.Symbol)
 		if !ok {
 			c.Say("Unable to include your asset of %s. This might be a temporary glitch. Please try again later.", asset.Symbol)
 			continue
@@ -199,25 +400,30 @@ func (c *Command) CommandPortfolio() {
 		var net float64
 		switch asset.Type {
 		case "long":
-			net = float64(asset.Quantity) * (quote.LastPrice - asset.CostBasis)
+			net = asset.Quantity * (quote.LastPrice - asset.CostBasis)
 		case "short":
-			net = float64(asset.Quantity) * (asset.CostBasis - quote.LastPrice)
-		default:
-			continue
+			net = asset.Quantity * (asset.CostBasis - quote.LastPrice)
+		}
+		value := asset.Quantity * quote.LastPrice
+
+		borrowed := ""
+		if asset.Type == "short" {
+			borrowed = format.Sprintf("$%.2f", asset.BorrowFees)
 		}
-		value := float64(asset.Quantity) * quote.LastPrice
 
 		portfolio = append(portfolio,
-			fmt.Sprintf("%5s | %8s | %8d | %12s | %12s | %12s | %12s",
-				asset.Type, asset.Symbol, asset.Quantity,
+			fmt.Sprintf("%4s | %5s | %8s | %8s | %12s | %12s | %12s | %12s | %12s",
+				asset.ID, asset.Type, asset.Symbol, formatShares(asset.Quantity),
 				format.Sprintf("$%.4f", asset.CostBasis),
 				format.Sprintf("$%.4f", quote.LastPrice),
 				format.Sprintf("$%.2f", value),
 				format.Sprintf("$%+.2f", net),
+				borrowed,
 			),
 		)
 		gains = gains + net
 		total = total + value
+		fees = fees + asset.BorrowFees
 		positions = positions + 1
 	}
 
@@ -227,9 +433,10 @@ func (c *Command) CommandPortfolio() {
 	}
 
 	portfolio = append(portfolio,
-		fmt.Sprintf("%44s %12s | %12s | %12s", "", "Totals:",
+		fmt.Sprintf("%51s %12s | %12s | %12s | %12s", "", "Totals:",
 			format.Sprintf("$%.2f", total),
 			format.Sprintf("$%+.2f", gains),
+			format.Sprintf("$%.2f", fees),
 		),
 	)
 
@@ -239,11 +446,12 @@ func (c *Command) CommandPortfolio() {
 /* ***********************************************************************************
  * Buy - Purchase a stock at market price
  *
- * Syntax: !buy [quantity:int] [symbol:str]
+ * Syntax: !buy [quantity:float|amount:"$"float|"max"] [symbol:str] ["open":optional]
  */
 func (c *Command) CommandBuy() {
 	var err error
-	var quantity int64
+	var quantity float64
+	var amount float64
 	var symbol string
 
 	if symbol, err = c.GetArgAsStockSymbol(1); err != nil {
@@ -251,50 +459,39 @@ func (c *Command) CommandBuy() {
 		return
 	}
 
-	if quantity, err = c.GetArgAsInteger(0); err != nil {
-		value, err := c.GetArgAsString(0)
-		if err != nil {
-			c.Say(invalid_arg, "quantity")
-			return
-		}
-
-		if strings.ToLower(value) != "max" {
-			c.Say(invalid_arg, "quantity")
-			return
-		}
-
-		funds := c.User.Funds
-		fmt.Println("???")
-		tradingview.GetQuote(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
-			cost_basis := quote.LastPrice
-			if quote.LivePrice != 0.00 && (quote.CurrentSession == "pre_market" || quote.CurrentSession == "post_market") {
-				cost_basis = quote.LivePrice
-			}
-
-			quantity = int64(math.Floor(funds / cost_basis))
-
-			fmt.Printf("Funds: %f, Cost: %f, quantity: %d\n", funds, cost_basis, quantity)
+	if value, _ := c.GetArgAsString(0); strings.ToLower(value) == "max" {
+		amount = c.User.available()
+	} else if quantity, amount, err = c.GetArgAsShares(0); err != nil {
+		c.Say(invalid_arg, "quantity")
+		return
+	}
 
-			c.User.CreatePosition("long", symbol, quantity, 0.0, c)
-			return true
-		})
+	onOpen, ok := c.MarketOrderTiming()
+	if !ok {
 		return
 	}
 
-	c.User.CreatePosition("long", symbol, quantity, 0.0, c)
+	c.TradeShares("buy", symbol, quantity, amount, func(quantity float64) {
+		if onOpen {
+			c.User.PlaceOrder(&Order{Type: "open_buy", Symbol: symbol, Quantity: quantity}, c)
+			return
+		}
+		c.User.CreatePosition("long", symbol, quantity, c)
+	})
 }
 
 /* ***********************************************************************************
  * Short - Short a stock, expecting the price to go down.
  *
- * Syntax: !short [quantity:int] [symbol:str]
+ * Syntax: !short [quantity:float|amount:"$"float] [symbol:str] ["open":optional]
  */
 func (c *Command) CommandShort() {
 	var err error
-	var quantity int64
+	var quantity float64
+	var amount float64
 	var symbol string
 
-	if quantity, err = c.GetArgAsInteger(0); err != nil {
+	if quantity, amount, err = c.GetArgAsShares(0); err != nil {
 		c.Say(invalid_arg, "quantity")
 		return
 	}
@@ -304,23 +501,36 @@ func (c *Command) CommandShort() {
 		return
 	}
 
-	c.User.CreatePosition("short", symbol, quantity, 0.0, c)
+	onOpen, ok := c.MarketOrderTiming()
+	if !ok {
+		return
+	}
+
+	c.TradeShares("short", symbol, quantity, amount, func(quantity float64) {
+		if onOpen {
+			c.User.PlaceOrder(&Order{Type: "open_short", Symbol: symbol, Quantity: quantity}, c)
+			return
+		}
+		c.User.CreatePosition("short", symbol, quantity, c)
+	})
 }
 
 /* ***********************************************************************************
  * Sell - Sell a regularly held stock for market price. If the user is holding multiple
  *        long positions on a stock, they can specify the cost basis they bought the
- *	  stock at to sell of those.
+ *	  stock at to sell of those, or the ID of the lot to sell from; otherwise lots
+ *	  are sold in the order of their lot relief method.
  *
- * Syntax: !sell [quantity:int] [symbol:str] [cost_basis:float:optional]
+ * Syntax: !sell [quantity:float|amount:"$"float] [symbol:str] [cost_basis:float|"lot" id:str:optional] ["open":optional]
  */
 func (c *Command) CommandSell() {
 	var err error
-	var quantity int64
+	var quantity float64
+	var amount float64
 	var symbol string
 	var basis float64
 
-	if quantity, err = c.GetArgAsInteger(0); err != nil {
+	if quantity, amount, err = c.GetArgAsShares(0); err != nil {
 		c.Say(invalid_arg, "quantity")
 		return
 	}
@@ -330,26 +540,47 @@ func (c *Command) CommandSell() {
 		return
 	}
 
-	// Optional
-	basis, _ = c.GetArgAsFloat(2)
+	// Optional; either the ID of the lot to close, or the price the shares were opened at
+	var lot string
+	if value, _ := c.GetArgAsString(2); strings.EqualFold(value, "lot") {
+		if lot, err = c.GetArgAsString(3); err != nil || lot == "" {
+			c.Say(invalid_arg, "lot ID")
+			return
+		}
+	} else {
+		basis, _ = c.GetArgAsFloat(2)
+	}
 
-	c.User.ClosePosition("long", symbol, quantity, basis, c)
+	onOpen, ok := c.MarketOrderTiming()
+	if !ok {
+		return
+	}
+
+	c.TradeShares("sell", symbol, quantity, amount, func(quantity float64) {
+		if onOpen {
+			c.User.PlaceOrder(&Order{Type: "open_sell", Symbol: symbol, Quantity: quantity}, c)
+			return
+		}
+		c.User.ClosePosition("long", symbol, quantity, lot, basis, c)
+	})
 }
 
 /* ***********************************************************************************
  * Cover - Cover a shorted stock at market price. If the user is holding multiple
  *         shorts on a stock, they can specify the cost basis they shorted the
- * 	   stock at to cover those.
+ * 	   stock at to cover those, or the ID of the lot to cover; otherwise lots are
+ * 	   covered in the order of their lot relief method.
  *
- * Syntax: !cover [quantity:int] [symbol:str] [cost_basis:float:optional]
+ * Syntax: !cover [quantity:float|amount:"$"float] [symbol:str] [cost_basis:float|"lot" id:str:optional] ["open":optional]
  */
 func (c *Command) CommandCover() {
 	var err error
-	var quantity int64
+	var quantity float64
+	var amount float64
 	var symbol string
 	var basis float64
 
-	if quantity, err = c.GetArgAsInteger(0); err != nil {
+	if quantity, amount, err = c.GetArgAsShares(0); err != nil {
 		c.Say(invalid_arg, "quantity")
 		return
 	}
@@ -359,72 +590,117 @@ func (c *Command) CommandCover() {
 		return
 	}
 
-	// Optional
-	basis, _ = c.GetArgAsFloat(2)
+	// Optional; either the ID of the lot to close, or the price the shares were opened at
+	var lot string
+	if value, _ := c.GetArgAsString(2); strings.EqualFold(value, "lot") {
+		if lot, err = c.GetArgAsString(3); err != nil || lot == "" {
+			c.Say(invalid_arg, "lot ID")
+			return
+		}
+	} else {
+		basis, _ = c.GetArgAsFloat(2)
+	}
+
+	onOpen, ok := c.MarketOrderTiming()
+	if !ok {
+		return
+	}
 
-	c.User.ClosePosition("short", symbol, quantity, basis, c)
+	c.TradeShares("cover", symbol, quantity, amount, func(quantity float64) {
+		if onOpen {
+			c.User.PlaceOrder(&Order{Type: "open_cover", Symbol: symbol, Quantity: quantity}, c)
+			return
+		}
+		c.User.ClosePosition("short", symbol, quantity, lot, basis, c)
+	})
 }
 
 /* ***********************************************************************************
- * Orders - get the pending order book of the initiator, or specified person.
+ * Orders - get the pending orders of the initiator, or specified person, along with
+ *          their IDs. Add `all` to include their recently filled, cancelled and expired
+ *          orders.
  *
- * Syntax: [!orders|!o] [@mention:optional]
+ * Syntax: [!orders|!o] [@mention:optional] ["all":optional]
  */
 func (c *Command) CommandO() { c.CommandOrders() }
 func (c *Command) CommandOrders() {
-	user := c.GetOptionalUserFromArg(0)
+	user := c.User
+	for i := range c.Args {
+		if value, _ := c.GetArgAsString(i); strings.HasPrefix(value, "<@") {
+			user = c.GetOptionalUserFromArg(i)
+		}
+	}
+	all := c.HasFlag("all")
 
 	pronoun := "Your"
 	if user.UserID != c.User.UserID {
 		pronoun = "Their"
 	}
 
-	if len(user.Portfolio) == 0 {
+	orders := user.ActiveOrders()
+	if all {
+		orders = user.Orders
+	}
+
+	if len(orders) == 0 {
 		c.Say("<@%s> doesn't have any pending orders! %s available funds are: $%.2f", user.UserID, pronoun, user.Funds)
 		return
 	}
 
-	portfolio := []string{
-		fmt.Sprintf("%11s | %8s | %8s | %14s | %12s", "Limit Type", "Symbol", "Qty", "Target Price", "Last Price"),
+	listing := []string{
+		fmt.Sprintf("%4s | %14s | %8s | %10s | %22s | %12s | %16s | %30s", "ID", "Order Type", "Symbol", "Qty", "Target Price", "Last Price", "Good", "Status"),
 	}
 
-	var positions int
-	for i := range user.Portfolio {
-		asset := user.Portfolio[i]
+	for i := range orders {
+		order := orders[i]
+
+		order_type := orderKindName(order.Type) + " " + orderSide(order.Type)
+		target := format.Sprintf("$%.4f", order.Target)
+		switch orderKind(order.Type) {
+		case "limit":
+			order_type = orderSide(order.Type)
+		case "open":
+			target = "at open"
+		case "stoplimit":
+			target = format.Sprintf("$%.2f/$%.2f", order.Target, order.Limit)
+		case "trail":
+			target = format.Sprintf("$%.4f", order.TrailStop())
+		}
+		if order.TakeProfit != 0 {
+			order_type = "bracket " + orderSide(order.Type)
+			target = format.Sprintf("$%.2f/$%.2f/$%.2f", order.Target, order.TakeProfit, order.StopLoss)
+		}
 
-		switch asset.Type {
-		case "limit_buy":
-			asset.Type = "buy"
-		case "limit_sell":
-			asset.Type = "sell"
-		case "limit_cover":
-			asset.Type = "cover"
-		default:
-			continue
+		quantity := formatShares(order.Quantity)
+		if order.Filled != 0 && order.Filled != order.Quantity {
+			quantity = formatShares(order.Filled) + "/" + formatShares(order.Quantity)
 		}
 
-		quote, ok := tradingview.GetCurrent(asset.Symbol)
-		if !ok {
-			c.Say("Unable to include your asset of %s. This might be a temporary glitch. Please try again later.", asset.Symbol)
-			continue
+		status := strings.ReplaceAll(order.Status, "_", " ")
+		if order.FillPrice != 0 {
+			status = status + format.Sprintf(" at $%.2f", order.FillPrice)
+		}
+		if order.Group != "" {
+			status = status + " (oco " + order.Group + ")"
 		}
 
-		portfolio = append(portfolio,
-			fmt.Sprintf("%11s | %8s | %8d | %14s | %12s",
-				asset.Type, asset.Symbol, asset.Quantity,
-				format.Sprintf("$%.4f", asset.CostBasis),
-				format.Sprintf("$%.4f", quote.LastPrice),
+		last := "-"
+		if quote, ok := quotes.GetCurrent(order.Symbol); ok {
+			last = format.Sprintf("$%.4f", quote.LastPrice)
+		}
+
+		listing = append(listing,
+			fmt.Sprintf("%4s | %14s | %8s | %10s | %22s | %12s | %16s | %30s",
+				order.ID, order_type, order.Symbol, quantity,
+				target,
+				last,
+				order.TimeInForceDescription(),
+				status,
 			),
 		)
-		positions = positions + 1
-	}
-
-	if positions == 0 {
-		c.Say("<@%s> doesn't have any pending orders! %s available funds are: $%.2f", user.UserID, pronoun, user.Funds)
-		return
 	}
 
-	c.Say("<@%s>'s pending orders:\n```%s```\nThey have $%.2f being held to cover buy orders, and $%.2f available for investing.", user.UserID, strings.Join(portfolio[:], "\n"), user.HeldFunds, user.Funds)
+	c.Say("<@%s>'s orders:\n```%s```\nThey have $%.2f being held to cover buy orders, and $%.2f available for investing.", user.UserID, strings.Join(listing[:], "\n"), user.HeldFunds, user.Funds)
 }
 
 /* ***********************************************************************************
@@ -433,16 +709,20 @@ func (c *Command) CommandOrders() {
  *         a limit sell is placed, when the stock hits the target price or goes above,
  *         then a sell order will be executed. When placing a limit order, funds to
  *         cover the order at the target price will be held until the order is
- *         finalized, or cancelled. Limit orders do not expire automatically.
+ *         finalized, or cancelled; a limit sell or cover reserves the shares it
+ *         closes instead, so they can't be closed twice. Limit orders are good until
+ *         cancelled, unless a time-in-force is given.
  *
- * Syntax: !limit [type:"buy"|"sell"] [quantity:int] [symbol:str] [target:float]
+ * Syntax: !limit [type:"buy"|"sell"] [quantity:float] [symbol:str] [target:float] [tif:"day"|"gtc"|"ioc"|"gtd" date:optional]
  */
 func (c *Command) CommandLimit() {
 	var err error
 	var limit string
-	var quantity int64
+	var quantity float64
 	var symbol string
 	var target float64
+	var tif string
+	var expires string
 
 	if limit, err = c.GetArgAsString(0); err != nil || limit == "" {
 		c.Say(invalid_arg, "limit type")
@@ -460,7 +740,7 @@ func (c *Command) CommandLimit() {
 		return
 	}
 
-	if quantity, err = c.GetArgAsInteger(1); err != nil {
+	if quantity, err = c.GetArgAsQuantity(1); err != nil {
 		c.Say(invalid_arg, "quantity")
 		return
 	}
@@ -475,40 +755,53 @@ func (c *Command) CommandLimit() {
 		return
 	}
 
+	if tif, expires, err = c.GetArgAsTimeInForce(4); err != nil {
+		c.Say(invalid_arg, "time in force (`day`, `gtc`, `ioc` or `gtd YYYY-MM-DD`)")
+		return
+	}
+
 	source := c
 	user := source.User
-	user.CreatePosition(limit, symbol, quantity, target, c)
+	user.PlaceOrder(&Order{
+		Type:        limit,
+		Symbol:      symbol,
+		Target:      target,
+		Quantity:    quantity,
+		TimeInForce: tif,
+		Expires:     expires,
+	}, c)
 }
 
 /* ***********************************************************************************
- * Cancel - Cancel a pending limit order.
+ * Stop - Create a protective stop order. A stop sell triggers when the stock falls to
+ *        the stop price or below, a stop buy or cover when it rises to the stop price
+ *        or above; once triggered the order is executed at the market price. Funds
+ *        to cover buy and cover orders at the stop price are held until the order is
+ *        finalized, or cancelled.
  *
- * Syntax: !limit [type:"buy"|"sell"] [quantity:int] [symbol:str] [target:float]
+ * Syntax: !stop [type:"buy"|"sell"|"cover"] [quantity:float] [symbol:str] [stop:float] [tif:"day"|"gtc"|"gtd" date:optional]
  */
-func (c *Command) CommandCancel() {
+func (c *Command) CommandStop() {
 	var err error
-	var limit string
-	var quantity int64
+	var side string
+	var quantity float64
 	var symbol string
-	var target float64
+	var stop float64
+	var tif string
+	var expires string
 
-	if limit, err = c.GetArgAsString(0); err != nil || limit == "" {
-		c.Say(invalid_arg, "limit type")
+	if side, err = c.GetArgAsString(0); err != nil || side == "" {
+		c.Say(invalid_arg, "stop type")
 		return
 	}
-	switch strings.ToLower(limit) {
-	case "buy":
-		limit = "limit_buy"
-	case "sell":
-		limit = "limit_sell"
-	case "cover":
-		limit = "limit_cover"
+	switch side = strings.ToLower(side); side {
+	case "buy", "sell", "cover":
 	default:
-		c.Say("Unknown limit type specified. Valid types are `buy` or `sell`.")
+		c.Say("Unknown stop type specified. Valid types are `buy`, `sell` or `cover`.")
 		return
 	}
 
-	if quantity, err = c.GetArgAsInteger(1); err != nil {
+	if quantity, err = c.GetArgAsQuantity(1); err != nil {
 		c.Say(invalid_arg, "quantity")
 		return
 	}
@@ -518,22 +811,330 @@ func (c *Command) CommandCancel() {
 		return
 	}
 
-	if target, err = c.GetArgAsFloat(3); err != nil {
+	if stop, err = c.GetArgAsFloat(3); err != nil || stop <= 0 {
+		c.Say(invalid_arg, "stop price")
+		return
+	}
+
+	if tif, expires, err = c.GetArgAsTimeInForce(4); err != nil {
+		c.Say(invalid_arg, "time in force (`day`, `gtc` or `gtd YYYY-MM-DD`)")
+		return
+	}
+
+	c.User.PlaceOrder(&Order{
+		Type:        "stop_" + side,
+		Symbol:      symbol,
+		Target:      stop,
+		Quantity:    quantity,
+		TimeInForce: tif,
+		Expires:     expires,
+	}, c)
+}
+
+/* ***********************************************************************************
+ * StopLimit - Create a stop-limit order. Triggers like a stop order, but rather than
+ *             executing at the market price, places a limit order at the limit price.
+ *             Funds to cover buy and cover orders at the limit price are held until
+ *             the order is finalized, or cancelled.
+ *
+ * Syntax: !stoplimit [type:"buy"|"sell"|"cover"] [quantity:float] [symbol:str] [stop:float] [limit:float] [tif:"day"|"gtc"|"gtd" date:optional]
+ */
+func (c *Command) CommandStoplimit() {
+	var err error
+	var side string
+	var quantity float64
+	var symbol string
+	var stop float64
+	var limit float64
+	var tif string
+	var expires string
+
+	if side, err = c.GetArgAsString(0); err != nil || side == "" {
+		c.Say(invalid_arg, "stop type")
+		return
+	}
+	switch side = strings.ToLower(side); side {
+	case "buy", "sell", "cover":
+	default:
+		c.Say("Unknown stop type specified. Valid types are `buy`, `sell` or `cover`.")
+		return
+	}
+
+	if quantity, err = c.GetArgAsQuantity(1); err != nil {
+		c.Say(invalid_arg, "quantity")
+		return
+	}
+
+	if symbol, err = c.GetArgAsStockSymbol(2); err != nil {
+		c.Say(invalid_arg, "stock symbol")
+		return
+	}
+
+	if stop, err = c.GetArgAsFloat(3); err != nil || stop <= 0 {
+		c.Say(invalid_arg, "stop price")
+		return
+	}
+
+	if limit, err = c.GetArgAsFloat(4); err != nil || limit <= 0 {
 		c.Say(invalid_arg, "limit price")
 		return
 	}
 
-	portfolio := c.User.Portfolio
-	for i := range portfolio {
-		asset := portfolio[i]
-		if asset.Type == limit && asset.Symbol == symbol && asset.Quantity == int(quantity) && asset.CostBasis == target {
-			c.Say("<@%s>, your limit order for %s has been cancelled.", c.User.UserID, asset.Symbol)
-			c.User.ClosePosition(asset.Type, asset.Symbol, int64(asset.Quantity), asset.CostBasis, c)
+	if tif, expires, err = c.GetArgAsTimeInForce(5); err != nil {
+		c.Say(invalid_arg, "time in force (`day`, `gtc` or `gtd YYYY-MM-DD`)")
+		return
+	}
+
+	c.User.PlaceOrder(&Order{
+		Type:        "stoplimit_" + side,
+		Symbol:      symbol,
+		Target:      stop,
+		Quantity:    quantity,
+		Limit:       limit,
+		TimeInForce: tif,
+		Expires:     expires,
+	}, c)
+}
+
+/* ***********************************************************************************
+ * Trail - Create a trailing stop sell order. The stop follows the highest price seen
+ *         since the order was placed, by either a dollar amount or a percentage, and
+ *         once the price falls back to the stop the shares are sold at the market
+ *         price.
+ *
+ * Syntax: !trail [type:"sell"] [quantity:float] [symbol:str] [trail:float|percent] [tif:"day"|"gtc"|"gtd" date:optional]
+ */
+func (c *Command) CommandTrail() {
+	var err error
+	var side string
+	var quantity float64
+	var symbol string
+	var trail float64
+	var percent bool
+	var tif string
+	var expires string
+
+	if side, err = c.GetArgAsString(0); err != nil || side == "" {
+		c.Say(invalid_arg, "trailing stop type")
+		return
+	}
+	if side = strings.ToLower(side); side != "sell" {
+		c.Say("Unknown trailing stop type specified. Only `sell` is supported.")
+		return
+	}
+
+	if quantity, err = c.GetArgAsQuantity(1); err != nil {
+		c.Say(invalid_arg, "quantity")
+		return
+	}
+
+	if symbol, err = c.GetArgAsStockSymbol(2); err != nil {
+		c.Say(invalid_arg, "stock symbol")
+		return
+	}
+
+	if trail, percent, err = c.GetArgAsAmountOrPercent(3); err != nil || trail <= 0 || (percent && trail >= 100) {
+		c.Say(invalid_arg, "trail amount")
+		return
+	}
+
+	if tif, expires, err = c.GetArgAsTimeInForce(4); err != nil {
+		c.Say(invalid_arg, "time in force (`day`, `gtc` or `gtd YYYY-MM-DD`)")
+		return
+	}
+
+	c.User.PlaceOrder(&Order{
+		Type:         "trail_" + side,
+		Symbol:       symbol,
+		Quantity:     quantity,
+		Trail:        trail,
+		TrailPercent: percent,
+		TimeInForce:  tif,
+		Expires:      expires,
+	}, c)
+}
+
+/* ***********************************************************************************
+ * Bracket - Create a limit order to buy (or short) a stock at the entry price, which
+ *           once filled places a limit order to take profit at the target price and a
+ *           stop order to stop losses at the stop price. The two share the bracket's
+ *           ID as their group, and filling either one cancels the other.
+ *
+ * Syntax: !bracket [type:"buy"|"short":optional] [quantity:float] [symbol:str] [entry:float] "target" [target:float] "stop" [stop:float] [tif:"day"|"gtc"|"gtd" date:optional]
+ */
+func (c *Command) CommandBracket() {
+	var err error
+	var quantity float64
+	var symbol string
+	var entry float64
+	var target float64
+	var stop float64
+	var tif string
+	var expires string
+
+	side := "buy"
+	position := 0
+	if value, _ := c.GetArgAsString(0); strings.EqualFold(value, "buy") || strings.EqualFold(value, "short") {
+		side = strings.ToLower(value)
+		position = 1
+	}
+
+	if quantity, err = c.GetArgAsQuantity(position); err != nil {
+		c.Say(invalid_arg, "quantity")
+		return
+	}
+
+	if symbol, err = c.GetArgAsStockSymbol(position + 1); err != nil {
+		c.Say(invalid_arg, "stock symbol")
+		return
+	}
+
+	if entry, err = c.GetArgAsFloat(position + 2); err != nil || entry <= 0 {
+		c.Say(invalid_arg, "entry price")
+		return
+	}
+
+	if value, _ := c.GetArgAsString(position + 3); !strings.EqualFold(value, "target") {
+		c.Say(invalid_arg, "`target` followed by the take-profit price")
+		return
+	}
+	if target, err = c.GetArgAsFloat(position + 4); err != nil || target <= 0 {
+		c.Say(invalid_arg, "take-profit price")
+		return
+	}
+
+	if value, _ := c.GetArgAsString(position + 5); !strings.EqualFold(value, "stop") {
+		c.Say(invalid_arg, "`stop` followed by the stop-loss price")
+		return
+	}
+	if stop, err = c.GetArgAsFloat(position + 6); err != nil || stop <= 0 {
+		c.Say(invalid_arg, "stop-loss price")
+		return
+	}
+
+	if tif, expires, err = c.GetArgAsTimeInForce(position + 7); err != nil || tif == TimeInForceIOC {
+		c.Say(invalid_arg, "time in force (`day`, `gtc` or `gtd YYYY-MM-DD`)")
+		return
+	}
+
+	if (side == "buy" && !(stop < entry && entry < target)) || (side == "short" && !(target < entry && entry < stop)) {
+		c.Say("<@%s>, the take-profit and stop-loss prices need to be on either side of the entry price; above and below it when buying, below and above it when shorting.", c.User.UserID)
+		return
+	}
+
+	c.User.PlaceOrder(&Order{
+		Type:        "limit_" + side,
+		Symbol:      symbol,
+		Quantity:    quantity,
+		Target:      entry,
+		TakeProfit:  target,
+		StopLoss:    stop,
+		TimeInForce: tif,
+		Expires:     expires,
+	}, c)
+}
+
+/* ***********************************************************************************
+ * Amend - Change the price or quantity of a pending order in place. The order can be
+ *         given by its ID, as shown in !orders, or as the side, quantity, symbol and
+ *         price of a limit order. Funds held for the order are adjusted to cover it
+ *         on its new terms.
+ *
+ * Syntax: !amend [id:str|type:"buy"|"sell"|"cover" quantity:float symbol:str target:float] ["price" price:float:optional] ["qty" quantity:float:optional]
+ */
+func (c *Command) CommandAmend() {
+	var err error
+	var id string
+	var quantity float64
+	var target float64
+
+	if id, err = c.GetArgAsString(0); err != nil || id == "" {
+		c.Say(invalid_arg, "order ID")
+		return
+	}
+
+	position := 1
+	switch side := strings.ToLower(id); side {
+	case "buy", "sell", "cover":
+		var matching float64
+		var symbol string
+		var price float64
+
+		if matching, err = c.GetArgAsQuantity(1); err != nil {
+			c.Say(invalid_arg, "quantity")
+			return
+		}
+
+		if symbol, err = c.GetArgAsStockSymbol(2); err != nil {
+			c.Say(invalid_arg, "stock symbol")
+			return
+		}
+
+		if price, err = c.GetArgAsFloat(3); err != nil {
+			c.Say(invalid_arg, "limit price")
+			return
+		}
+
+		id = ""
+		orders := c.User.ActiveOrders()
+		for i := range orders {
+			order := orders[i]
+			if order.Type == "limit_"+side && order.Symbol == symbol && order.Quantity == matching && order.Target == price {
+				id = order.ID
+				break
+			}
+		}
+		if id == "" {
+			c.Say("I was unable to find that order; make sure you entered the right information.")
+			return
+		}
+		position = 4
+	default:
+		id = strings.Trim(id, "`")
+	}
+
+	for ; position < len(c.Args); position = position + 2 {
+		switch strings.ToLower(c.Args[position]) {
+		case "price":
+			if target, err = c.GetArgAsFloat(position + 1); err != nil || target <= 0 {
+				c.Say(invalid_arg, "price")
+				return
+			}
+		case "qty", "quantity":
+			if quantity, err = c.GetArgAsQuantity(position + 1); err != nil || quantity <= 0 {
+				c.Say(invalid_arg, "quantity")
+				return
+			}
+		default:
+			c.Say(invalid_arg, "`price` or `qty` to amend")
 			return
 		}
 	}
 
-	c.Say("I was unable to find that order; make sure you entered the right information.")
+	if target == 0 && quantity == 0 {
+		c.Say("<@%s>, tell me what to amend; add `price` and/or `qty` followed by the new value.", c.User.UserID)
+		return
+	}
+
+	c.User.AmendOrder(id, quantity, target, c)
+}
+
+/* ***********************************************************************************
+ * Cancel - Cancel a pending order by its ID, as shown in !orders, refunding any funds
+ *          held for it.
+ *
+ * Syntax: !cancel [id:str]
+ */
+func (c *Command) CommandCancel() {
+	var err error
+	var id string
+
+	if id, err = c.GetArgAsString(0); err != nil || id == "" {
+		c.Say(invalid_arg, "order ID")
+		return
+	}
+
+	c.User.CancelOrder(strings.Trim(id, "`"), c)
 }
 
 /* ***********************************************************************************
@@ -542,11 +1143,28 @@ func (c *Command) CommandCancel() {
  * Syntax: !liquidate
  */
 func (c *Command) CommandLiquidate() {
+	if !c.CheckMarketOpen() {
+		return
+	}
+
+	var cancelled []Order
+	c.User.Update(func(user *User) error {
+		cancelled = nil
+		orders := user.ActiveOrders()
+		for i := range orders {
+			order, _ := user.cancelOrder(orders[i].ID, LedgerLiquidation, calendar.Session(time.Now()))
+			cancelled = append(cancelled, *order)
+		}
+		return nil
+	})
+	for i := range cancelled {
+		c.Say("<@%s>, your %s has been cancelled.", c.User.UserID, cancelled[i].Description())
+	}
+
 	portfolio := c.User.Portfolio
 	for i := range portfolio {
 		asset := portfolio[i]
-		c.Say("<@%s>, your limit order for %d of %s at $%.2f has been cancelled.", c.User.UserID, asset.Quantity, asset.Symbol, asset.CostBasis)
-		c.User.ClosePosition(asset.Type, asset.Symbol, int64(asset.Quantity), asset.CostBasis, c)
+		c.User.closePosition(LedgerLiquidation, asset.Type, asset.Symbol, asset.Quantity, asset.ID, 0, c)
 	}
 }
 
@@ -559,54 +1177,329 @@ func (c *Command) CommandBankruptcy() {
 	user := c.User
 	t := time.Now()
 
-	Redis.Delete(user.UserID)
+	Storage.Delete(user.UserID, &LedgerEntry{
+		Time:   t,
+		Event:  LedgerBankruptcy,
+		Amount: -user.Funds,
+		Held:   -user.HeldFunds,
+	})
 
 	c.Say("<!channel> Notice is hereby given, that on the %s day of %s, A. D. %s, <@%s> was duly adjudicated bankrupt. If they owed you anything, tough shit.\n", humanize.Ordinal(t.Day()), t.Month(), strconv.Itoa(t.Year()), user.UserID)
 }
 
 /* ***********************************************************************************
- * Leaderboard - Show the leaderboard based on total funds and portfolio value
+ * Leaderboard - Show the leaderboard based on total funds and portfolio value, or
+ *               optionally ranked by lifetime realized gains.
  *
- * Syntax: !leaderboard
+ * Syntax: [!leaderboard|!l] ["networth"|"pnl":optional]
  */
 func (c *Command) CommandL() { c.CommandLeaderboard() }
 func (c *Command) CommandLeaderboard() {
-	users := Redis.GetAllUsers()
+	ranking, _ := c.GetArgAsString(0)
+	ranking = strings.ToLower(ranking)
+
+	if ranking != "" && ranking != "networth" && ranking != "pnl" {
+		c.Say("Unknown leaderboard ranking specified. Valid rankings are `networth` or `pnl`.")
+		return
+	}
+
+	users := Storage.GetAllUsers()
 
 	type LeaderBoardEntry struct {
 		UserName string
 		NetWorth float64
+		Realized float64
+		Stale    bool
 	}
 
 	var leaderboard []*LeaderBoardEntry
+	var stale bool
 
 	for i := range users {
 		user := users[i]
+		entry := &LeaderBoardEntry{
+			UserName: user.FullName,
+			Realized: user.RealizedGains,
+		}
+
 		networth := 0.0
 		for j := range user.Portfolio {
 			asset := user.Portfolio[j]
-			if quote, ok := tradingview.GetCurrent(asset.Symbol); ok {
-				networth = networth + (float64(asset.Quantity) * quote.LastPrice)
+			if quote, ok := quotes.GetCurrent(asset.Symbol); ok {
+				value := asset.Quantity * quote.LastPrice
+				if asset.Margin {
+					value = -value
+				}
+				networth = networth + value
+				if quote.IsStale() {
+					entry.Stale = true
+					stale = true
+				}
 			}
 		}
 
-		leaderboard = append(leaderboard, &LeaderBoardEntry{
-			UserName: user.FullName,
-			NetWorth: networth + user.Funds + user.HeldFunds,
-		})
+		entry.NetWorth = networth + user.Funds + user.HeldFunds
+		leaderboard = append(leaderboard, entry)
 	}
 
 	sort.Slice(leaderboard[:], func(i, j int) bool {
+		if ranking == "pnl" {
+			return leaderboard[i].Realized > leaderboard[j].Realized
+		}
 		return leaderboard[i].NetWorth > leaderboard[j].NetWorth
 	})
 
 	composed := []string{
-		fmt.Sprintf("%2s | %34s | %18s", "#", "Bag Holder", "Net Worth"),
+		fmt.Sprintf("%2s | %34s | %18s | %18s", "#", "Bag Holder", "Net Worth", "Realized P&L"),
 	}
 
 	for i := range leaderboard {
-		composed = append(composed, fmt.Sprintf("%2d | %34s | %18s", i, leaderboard[i].UserName, format.Sprintf("$%.2f", leaderboard[i].NetWorth)))
+		networth := format.Sprintf("$%.2f", leaderboard[i].NetWorth)
+		if leaderboard[i].Stale {
+			networth = "*" + networth
+		}
+
+		composed = append(composed, fmt.Sprintf("%2d | %34s | %18s | %18s", i, leaderboard[i].UserName,
+			networth,
+			format.Sprintf("$%+.2f", leaderboard[i].Realized),
+		))
+	}
+
+	if stale {
+		composed = append(composed, "", "* valued using one or more stale quotes")
 	}
 
 	c.Say("The current leaderboard:\n```%s```", strings.Join(composed[:], "\n"))
 }
+
+/* ***********************************************************************************
+ * History - show the most recent ledger entries of the initiator, or specified person,
+ *           optionally limited to a single symbol.
+ *
+ * Syntax: !history [@mention:optional] [symbol:str:optional] [count:int:optional]
+ */
+func (c *Command) CommandHistory() {
+	user := c.User
+	symbol := ""
+	count := int64(10)
+
+	for i := range c.Args {
+		if c.Args[i] == "" {
+			continue
+		}
+
+		if value, err := c.GetArgAsInteger(i); err == nil {
+			count = value
+		} else if value, err := c.GetArgAsStockSymbol(i); err == nil {
+			symbol = value
+		} else {
+			user = c.GetOptionalUserFromArg(i)
+		}
+	}
+
+	entries, err := Storage.GetLedger(user.UserID)
+	if err != nil {
+		c.Say("I was unable to retrieve the transaction history for <@%s>. This might be a temporary glitch. Please try again later.", user.UserID)
+		return
+	}
+
+	var matched []*LedgerEntry
+	for i := len(entries) - 1; i >= 0 && int64(len(matched)) < count; i-- {
+		if symbol == "" || entries[i].Symbol == symbol {
+			matched = append(matched, entries[i])
+		}
+	}
+
+	if len(matched) == 0 {
+		c.Say("<@%s> doesn't have any transactions on record.", user.UserID)
+		return
+	}
+
+	history := []string{
+		fmt.Sprintf("%12s | %11s | %11s | %8s | %8s | %12s | %14s | %14s", "Date", "Event", "Type", "Symbol", "Qty", "Price", "Amount", "Funds"),
+	}
+
+	for i := range matched {
+		entry := matched[i]
+		history = append(history,
+			fmt.Sprintf("%12s | %11s | %11s | %8s | %8s | %12s | %14s | %14s",
+				entry.Time.Format("Jan 02 15:04"), entry.Event, entry.Type, entry.Symbol, formatShares(entry.Quantity),
+				format.Sprintf("$%.4f", entry.Price),
+				format.Sprintf("$%+.2f", entry.Amount),
+				format.Sprintf("$%.2f", entry.Funds),
+			),
+		)
+	}
+
+	c.Say("<@%s>'s transaction history:\n```%s```", user.UserID, strings.Join(history[:], "\n"))
+}
+
+/* ***********************************************************************************
+ * PnL - show the realized and unrealized profit and loss of the initiator, or
+ *       specified person, optionally limiting realized gains to a period.
+ *
+ * Syntax: !pnl [@mention:optional] ["today"|"week"|"month"|"year"|"all":optional]
+ */
+func (c *Command) CommandPnl() {
+	user := c.User
+	period := "all"
+
+	for i := range c.Args {
+		if value, _ := c.GetArgAsString(i); strings.HasPrefix(value, "<@") {
+			user = c.GetOptionalUserFromArg(i)
+		} else if value != "" {
+			period = strings.ToLower(value)
+		}
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var realized float64
+	switch period {
+	case "today":
+		realized = user.RealizedSince(today)
+	case "week":
+		realized = user.RealizedSince(today.AddDate(0, 0, -int(today.Weekday())))
+	case "month":
+		realized = user.RealizedSince(today.AddDate(0, 0, 1-today.Day()))
+	case "year":
+		realized = user.RealizedSince(today.AddDate(0, 0, 1-today.YearDay()))
+	case "all":
+		realized = user.RealizedGains
+	default:
+		c.Say("Unknown period specified. Valid periods are `today`, `week`, `month`, `year` or `all`.")
+		return
+	}
+
+	var unrealized float64
+	for i := range user.Portfolio {
+		asset := user.Portfolio[i]
+		quote, ok := quotes.GetCurrent(asset.Symbol)
+		if !ok {
+			continue
+		}
+
+		switch asset.Type {
+		case "long":
+			unrealized = unrealized + asset.Quantity*(quote.LastPrice-asset.CostBasis)
+		case "short":
+			unrealized = unrealized + asset.Quantity*(asset.CostBasis-quote.LastPrice)
+		}
+	}
+
+	symbols := make([]string, 0, len(user.RealizedBySymbol))
+	for symbol := range user.RealizedBySymbol {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	breakdown := []string{
+		fmt.Sprintf("%8s | %14s", "Symbol", "Realized"),
+	}
+	for i := range symbols {
+		breakdown = append(breakdown, fmt.Sprintf("%8s | %14s", symbols[i], format.Sprintf("$%+.2f", user.RealizedBySymbol[symbols[i]])))
+	}
+
+	response := format.Sprintf("<@%s> has realized $%+.2f (%s), and has $%+.2f unrealized in open positions.", user.UserID, realized, period, unrealized)
+	if len(symbols) > 0 {
+		response = response + fmt.Sprintf("\nLifetime realized gains by symbol:\n```%s```", strings.Join(breakdown[:], "\n"))
+	}
+
+	c.Say(response)
+}
+
+/* ***********************************************************************************
+ * Settings - show the settings of the initiator, or change one of them. The lot relief
+ *            method decides which lots are closed first when a position is only
+ *            partly closed.
+ *
+ * Syntax: !settings ["lots" method:"fifo"|"lifo"|"hifo"|"average":optional]
+ */
+func (c *Command) CommandSettings() {
+	setting, _ := c.GetArgAsString(0)
+
+	switch strings.ToLower(setting) {
+	case "":
+		c.Say("<@%s>'s settings:\n```lots: %s```\nChange them with e.g. `!settings lots hifo`.", c.User.UserID, c.User.lotMethod())
+	case "lots":
+		method, _ := c.GetArgAsString(1)
+		method = strings.ToLower(method)
+
+		valid := false
+		for i := range LotMethods {
+			valid = valid || LotMethods[i] == method
+		}
+		if !valid {
+			c.Say(invalid_arg, "lot relief method (`fifo`, `lifo`, `hifo` or `average`)")
+			return
+		}
+
+		err := c.User.Update(func(user *User) error {
+			user.LotMethod = method
+			return nil
+		})
+		if err != nil {
+			c.User.log(map[string]interface{}{
+				"method":     "CommandSettings",
+				"lot_method": method,
+			}).WithError(err).Error("Unable to save settings.")
+			c.Say("<@%s>, something went wrong saving your settings; nothing was changed. Wanna try that again?", c.User.UserID)
+			return
+		}
+
+		c.Say("<@%s> will now close their lots %s.", c.User.UserID, lotMethodDescription(method))
+	default:
+		c.Say("Unknown setting specified. Valid settings are `lots`.")
+	}
+}
+
+/* ***********************************************************************************
+ * Action - apply a corporate action to every player holding the stock, or with pending
+ *          orders in it, effective immediately; the ex-date is the next trading day.
+ *          Only admins may use this.
+ *
+ * Syntax: !action ["split" symbol:str ratio:str|"dividend" symbol:str amount:float]
+ */
+func (c *Command) CommandAction() {
+	if !settings.Admins[c.User.UserID] {
+		c.Say("<@%s>, only admins can announce corporate actions.", c.User.UserID)
+		return
+	}
+
+	// Quotes keep their old prices until the ex-date, so actions take effect from the
+	// next session, and can't be applied while players can still trade on them.
+	now := time.Now()
+	kind, _ := c.GetArgAsString(0)
+	action := CorporateAction{
+		Type: strings.ToLower(kind),
+		Date: calendar.TradingDate(now).Format(CALENDAR_DATE_FORMAT),
+	}
+
+	var err error
+	if action.Symbol, err = c.GetArgAsStockSymbol(1); err != nil {
+		c.Say(invalid_arg, "stock symbol")
+		return
+	}
+
+	switch action.Type {
+	case ActionSplit:
+		value, _ := c.GetArgAsString(2)
+		if action.Ratio, err = parseRatio(value); err != nil {
+			c.Say(invalid_arg, "split ratio (e.g. `4:1`, or `1:10` for a reverse split)")
+			return
+		}
+	case ActionDividend:
+		if action.Amount, err = c.GetArgAsFloat(2); err != nil || action.Amount <= 0 {
+			c.Say(invalid_arg, "dividend per share")
+			return
+		}
+	default:
+		c.Say("Unknown corporate action specified. Valid actions are `split` and `dividend`.")
+		return
+	}
+
+	if err := ApplyCorporateAction(action, now, DefaultSource(c.User)); err == ErrTradingOpen {
+		c.Say("<@%s>, corporate actions can only be applied while the market is closed to trading. Try again after %s.", c.User.UserID, calendar.Format(calendar.NextTradingEnd(now)))
+	}
+}
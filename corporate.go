@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of corporate actions.
+const (
+	ActionSplit    = "split"
+	ActionDividend = "dividend"
+)
+
+var ErrTradingOpen = errors.New("trading is open")
+
+// A stock split or cash dividend, taking effect on its ex-date. Splits give Ratio new
+// shares for every share held (0.1 for a 1-for-10 reverse split), and dividends pay
+// Amount per share.
+type CorporateAction struct {
+	ID     string  `json:"id"`
+	Type   string  `json:"type"`
+	Symbol string  `json:"symbol"`
+	Date   string  `json:"date"`
+	Ratio  float64 `json:"ratio,omitempty"`
+	Amount float64 `json:"amount,omitempty"`
+}
+
+// Returns the ID the action is applied under; its own, or one made up from its type,
+// symbol and ex-date.
+func (a *CorporateAction) Key() string {
+	if a.ID != "" {
+		return a.ID
+	}
+
+	return a.Type + ":" + a.Symbol + ":" + a.Date
+}
+
+// Returns whether the action applies to a lot or order in the symbol opened for the
+// given trading day; only those from before the ex-date are entitled to it.
+func (a *CorporateAction) appliesTo(symbol string, opened string) bool {
+	return symbol == a.Symbol && opened < a.Date
+}
+
+// Returns the trading day the order was placed for.
+func (o *Order) placedFor() string {
+	return calendar.TradingDate(o.Created).Format(CALENDAR_DATE_FORMAT)
+}
+
+// Describe the action, for messages to users.
+func (a *CorporateAction) Description() string {
+	if a.Type == ActionDividend {
+		return format.Sprintf("a dividend of $%.4f per share", a.Amount)
+	}
+
+	if a.Ratio < 1 {
+		return format.Sprintf("a 1-for-%g reverse split", math.Round(1/a.Ratio*1000)/1000)
+	}
+
+	return format.Sprintf("a %g-for-1 split", a.Ratio)
+}
+
+// Returns the split ratio in either `4:1` or `4` form as the number of new shares for
+// every share held.
+func parseRatio(value string) (float64, error) {
+	parts := strings.SplitN(value, ":", 2)
+
+	ratio, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(parts) == 2 {
+		old, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return 0, err
+		}
+		if old <= 0 {
+			return 0, fmt.Errorf("invalid split ratio %q", value)
+		}
+		ratio = ratio / old
+	}
+
+	if ratio <= 0 {
+		return 0, fmt.Errorf("invalid split ratio %q", value)
+	}
+
+	return ratio, nil
+}
+
+// Load the corporate actions from the specified file; either a JSON array of actions,
+// or a CSV file with a header row, using the same field names:
+//
+//	[{"type": "split", "symbol": "AAPL", "date": "2026-11-02", "ratio": 4},
+//	 {"type": "dividend", "symbol": "MSFT", "date": "2026-11-19", "amount": 0.83}]
+//
+// Split ratios in CSV files may also be written as `4:1`.
+func LoadCorporateActions(path string) (actions []CorporateAction, err error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		actions, err = parseCorporateActionsCSV(string(raw))
+	} else {
+		err = json.Unmarshal(raw, &actions)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing corporate actions %s: %v", path, err)
+	}
+
+	for i := range actions {
+		actions[i].Type = strings.ToLower(actions[i].Type)
+		actions[i].Symbol = strings.ToUpper(actions[i].Symbol)
+	}
+
+	return actions, nil
+}
+
+func parseCorporateActionsCSV(raw string) (actions []CorporateAction, err error) {
+	rows, err := csv.NewReader(strings.NewReader(raw)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) < 1 {
+		return nil, nil
+	}
+
+	header := rows[0]
+	for _, row := range rows[1:] {
+		var action CorporateAction
+		for i := range row {
+			if i >= len(header) || row[i] == "" {
+				continue
+			}
+
+			switch strings.ToLower(header[i]) {
+			case "id":
+				action.ID = row[i]
+			case "type":
+				action.Type = row[i]
+			case "symbol":
+				action.Symbol = row[i]
+			case "date":
+				action.Date = row[i]
+			case "ratio":
+				action.Ratio, err = parseRatio(row[i])
+			case "amount":
+				action.Amount, err = strconv.ParseFloat(row[i], 64)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+// Apply every corporate action in the file named by CORPORATE_ACTIONS_FILE whose
+// ex-date has come by the next session, as long as players can't trade. Actions already
+// applied to a player are skipped, as are lots and orders opened on or after an action's
+// ex-date, so the whole file can be gone through on every run.
+func ApplyCorporateActions(now time.Time) {
+	path := os.Getenv("CORPORATE_ACTIONS_FILE")
+	if path == "" || calendar.CanTrade(now) {
+		return
+	}
+
+	actions, err := LoadCorporateActions(path)
+	if err != nil {
+		log.Errorf("Unable to load corporate actions: %v", err)
+		return
+	}
+
+	due := calendar.TradingDate(now).Format(CALENDAR_DATE_FORMAT)
+	for i := range actions {
+		if actions[i].Date <= due {
+			ApplyCorporateAction(actions[i], now, DefaultSource(nil))
+		}
+	}
+}
+
+// Apply the corporate action to every player holding the symbol, or with pending orders
+// in it. Actions can only be applied while players can't trade at the given time, as quotes stay at their
+// old prices until the ex-date; ErrTradingOpen is returned otherwise. Splits wait for
+// a quote of the symbol, to pay fractional shares out at, before they're applied.
+func ApplyCorporateAction(action CorporateAction, now time.Time, source *Command) error {
+	if calendar.CanTrade(now) {
+		return ErrTradingOpen
+	}
+
+	if action.Type != ActionSplit {
+		applyCorporateAction(action, 0, source)
+		return nil
+	}
+
+	// Actions are applied ahead of the ex-date, while the latest quote is still from
+	// before the split; fractional shares are paid out at the price it splits to.
+	if quote, ok := quotes.GetCurrent(action.Symbol); ok && quote.LastPrice != 0 {
+		applyCorporateAction(action, quote.LastPrice/action.Ratio, source)
+		return nil
+	}
+
+	quotes.OnUpdate(action.Symbol, func(quote TradingViewQuote) bool {
+		if quote.LastPrice == 0 {
+			return false
+		}
+
+		if calendar.CanTrade(time.Now()) {
+			log.WithField("action", action.Key()).Warn("No quote arrived before trading opened; leaving the corporate action for the next run.")
+			return true
+		}
+
+		applyCorporateAction(action, quote.LastPrice/action.Ratio, source)
+		return true
+	})
+
+	return nil
+}
+
+// Apply the corporate action at the price, and announce it if anyone was affected, or
+// tell the player who applied it if nobody was.
+func applyCorporateAction(action CorporateAction, price float64, source *Command) {
+	log := log.WithFields(log.Fields{
+		"method": "ApplyCorporateAction",
+		"action": action.Key(),
+		"type":   action.Type,
+		"symbol": action.Symbol,
+		"ratio":  action.Ratio,
+		"amount": action.Amount,
+		"price":  price,
+	})
+
+	var players int
+	var paid float64
+	Storage.ForEach(func(user User) {
+		if !user.affectedBy(action) {
+			return
+		}
+
+		var applied bool
+		var amount float64
+		var adjusted []Order
+		err := user.Update(func(user *User) error {
+			applied, amount, adjusted = user.applyCorporateAction(action, price, calendar.Session(time.Now()))
+			return nil
+		})
+		if err != nil {
+			log.WithField("user_id", user.UserID).WithError(err).Error("Unable to apply corporate action.")
+			return
+		}
+		if !applied {
+			return
+		}
+
+		players = players + 1
+		paid = paid + amount
+		for i := range adjusted {
+			user.WatchOrder(&adjusted[i], DefaultSource(&user))
+		}
+	})
+
+	if players == 0 {
+		if source.User != nil {
+			source.Say("<@%s>, there was nothing to adjust; nobody holds %s or has orders in it, or they were adjusted for this already.", source.User.UserID, action.Symbol)
+		}
+		return
+	}
+
+	log.WithField("players", players).Info("Applied corporate action.")
+	switch action.Type {
+	case ActionSplit:
+		source.Say("%s is undergoing %s, effective %s. The positions and pending orders of %d players have been adjusted to match; any fractional shares were paid out in cash.", action.Symbol, action.Description(), action.Date, players)
+	case ActionDividend:
+		source.Say("%s is paying %s, going ex-dividend %s. $%.2f has been paid out across %d players; shorts owe the dividend instead.", action.Symbol, action.Description(), action.Date, paid, players)
+	}
+}
+
+// Returns whether the corporate action may affect the user; whether they hold lots of
+// the symbol, or have pending orders in it, from before the ex-date, and the action
+// hasn't been applied to them yet.
+func (u *User) affectedBy(action CorporateAction) bool {
+	for i := range u.CorporateActions {
+		if u.CorporateActions[i] == action.Key() {
+			return false
+		}
+	}
+
+	for i := range u.Portfolio {
+		if action.appliesTo(u.Portfolio[i].Symbol, u.Portfolio[i].Opened) {
+			return true
+		}
+	}
+
+	orders := u.ActiveOrders()
+	for i := range orders {
+		if action.appliesTo(orders[i].Symbol, orders[i].placedFor()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Apply the corporate action to the user's lots and pending orders, unless it has been
+// already. Returns whether it was applied, the dividends paid (or owed, on shorts), and
+// the orders that were adjusted and need watching on their new terms.
+func (u *User) applyCorporateAction(action CorporateAction, price float64, session string) (applied bool, paid float64, adjusted []Order) {
+	if !u.affectedBy(action) {
+		return false, 0, nil
+	}
+
+	switch action.Type {
+	case ActionSplit:
+		adjusted = u.split(action, price, session)
+	case ActionDividend:
+		paid, adjusted = u.payDividend(action, session)
+	default:
+		return false, 0, nil
+	}
+
+	u.CorporateActions = append(u.CorporateActions, action.Key())
+	return true, paid, adjusted
+}
+
+// Split the user's lots of the symbol, and their pending orders in it, by the ratio of
+// the action. Lots keep their cost, spread over the new number of shares; fractional
+// shares left over are closed at the price, as cash in lieu. Lots and orders opened on
+// or after the ex-date are at post-split prices already, and are left alone.
+func (u *User) split(action CorporateAction, price float64, session string) (adjusted []Order) {
+	var orders []*Order
+	for i := range u.Orders {
+		if u.Orders[i].Active() && action.appliesTo(u.Orders[i].Symbol, u.Orders[i].placedFor()) {
+			orders = append(orders, u.Orders[i])
+			u.release(u.Orders[i], u.Orders[i].Reserved())
+		}
+	}
+
+	var portfolio []*Asset
+	for i := range u.Portfolio {
+		asset := u.Portfolio[i]
+		if !action.appliesTo(asset.Symbol, asset.Opened) {
+			portfolio = append(portfolio, asset)
+			continue
+		}
+
+		shares := asset.Quantity * action.Ratio
+		asset.Quantity = floorShares(shares)
+		asset.CostBasis = asset.CostBasis / action.Ratio
+
+		entry := &LedgerEntry{
+			Event:    LedgerSplit,
+			LotID:    asset.ID,
+			Type:     asset.Type,
+			Symbol:   asset.Symbol,
+			Quantity: asset.Quantity,
+			Price:    price,
+			Basis:    asset.CostBasis,
+			Ratio:    action.Ratio,
+			Session:  session,
+		}
+
+		if fraction := shares - asset.Quantity; fraction > 0.000001 && price != 0 {
+			value := fraction * price
+			cost := fraction * asset.CostBasis
+			switch {
+			case asset.Type == "long":
+				entry.Amount = value
+				entry.Gain = value - cost
+			case asset.Margin:
+				entry.Amount = -value
+				entry.Gain = cost - value
+			default:
+				entry.Amount = cost + (cost - value)
+				entry.Gain = cost - value
+			}
+
+			u.Funds = u.Funds + entry.Amount
+			u.realize(asset.Symbol, entry.Gain, time.Now())
+		}
+		u.record(entry)
+
+		if asset.Quantity > 0 {
+			portfolio = append(portfolio, asset)
+		}
+	}
+	u.Portfolio = portfolio
+
+	for i := range orders {
+		order := orders[i]
+		held := order.HoldPrice() * order.Remaining()
+
+		order.adjustForSplit(action.Ratio)
+		if order.ReservesShares() {
+			if available := u.unreserved(orderPosition(order.Type), order.Symbol); order.Remaining() > available {
+				order.Quantity = roundShares(order.Filled + available)
+			}
+			u.reserve(order, order.Remaining())
+		}
+
+		var difference float64
+		if order.HoldsFunds() {
+			difference = order.HoldPrice()*order.Remaining() - held
+		}
+		u.Funds = u.Funds - difference
+		u.HeldFunds = u.HeldFunds + difference
+		u.record(&LedgerEntry{
+			Event:    LedgerSplit,
+			OrderID:  order.ID,
+			Type:     order.Type,
+			Symbol:   order.Symbol,
+			Quantity: order.Quantity,
+			Price:    order.Target,
+			Basis:    order.Target,
+			Limit:    order.Limit,
+			Ratio:    action.Ratio,
+			Lots:     append([]LotShares{}, order.Lots...),
+			Amount:   -difference,
+			Held:     difference,
+			Session:  session,
+		})
+
+		if order.Remaining() == 0 {
+			u.cancelOrder(order.ID, LedgerCancel, session)
+			continue
+		}
+		adjusted = append(adjusted, *order)
+	}
+
+	return adjusted
+}
+
+// Adjust the quantities and prices of the order for a split of the ratio. Whole shares
+// are kept of both what has been filled and what is still to be filled.
+func (o *Order) adjustForSplit(ratio float64) {
+	remaining := floorShares(o.Remaining() * ratio)
+	o.Filled = floorShares(o.Filled * ratio)
+	o.Quantity = roundShares(o.Filled + remaining)
+
+	o.Target = o.Target / ratio
+	o.Limit = o.Limit / ratio
+	o.HighWater = o.HighWater / ratio
+	o.TakeProfit = o.TakeProfit / ratio
+	o.StopLoss = o.StopLoss / ratio
+	o.FillPrice = o.FillPrice / ratio
+	if !o.TrailPercent {
+		o.Trail = o.Trail / ratio
+	}
+	o.Updated = time.Now()
+}
+
+// Pay the user the dividend on each of their long lots of the symbol opened before the
+// ex-date, and charge it on each of their shorts. Pending limit orders to buy, and stop
+// orders to sell, have their prices lowered by the dividend, as the price drops by that
+// much on the ex-date.
+func (u *User) payDividend(action CorporateAction, session string) (paid float64, adjusted []Order) {
+	for i := range u.Portfolio {
+		asset := u.Portfolio[i]
+		if !action.appliesTo(asset.Symbol, asset.Opened) {
+			continue
+		}
+
+		amount := action.Amount * asset.Quantity
+		if asset.Type == "short" {
+			amount = -amount
+		}
+
+		u.Funds = u.Funds + amount
+		u.realize(asset.Symbol, amount, time.Now())
+		u.record(&LedgerEntry{
+			Event:    LedgerDividend,
+			LotID:    asset.ID,
+			Type:     asset.Type,
+			Symbol:   asset.Symbol,
+			Quantity: asset.Quantity,
+			Price:    action.Amount,
+			Basis:    asset.CostBasis,
+			Amount:   amount,
+			Gain:     amount,
+			Session:  session,
+		})
+		paid = paid + amount
+	}
+
+	for i := range u.Orders {
+		order := u.Orders[i]
+		if !order.Active() || !action.appliesTo(order.Symbol, order.placedFor()) {
+			continue
+		}
+
+		switch {
+		case orderKind(order.Type) == "limit" && orderSide(order.Type) == "buy":
+		case (orderKind(order.Type) == "stop" || orderKind(order.Type) == "stoplimit") && orderSide(order.Type) == "sell":
+		default:
+			continue
+		}
+
+		held := order.HoldPrice() * order.Remaining()
+		order.Target = math.Max(0.0001, order.Target-action.Amount)
+		if order.Limit != 0 {
+			order.Limit = math.Max(0.0001, order.Limit-action.Amount)
+		}
+		order.Updated = time.Now()
+
+		var difference float64
+		if order.HoldsFunds() {
+			difference = order.HoldPrice()*order.Remaining() - held
+		}
+		u.Funds = u.Funds - difference
+		u.HeldFunds = u.HeldFunds + difference
+		u.record(&LedgerEntry{
+			Event:    LedgerDividend,
+			OrderID:  order.ID,
+			Type:     order.Type,
+			Symbol:   order.Symbol,
+			Quantity: order.Quantity,
+			Price:    action.Amount,
+			Basis:    order.Target,
+			Limit:    order.Limit,
+			Amount:   -difference,
+			Held:     difference,
+			Session:  session,
+		})
+		adjusted = append(adjusted, *order)
+	}
+
+	return paid, adjusted
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitSkipsLotsOpenedAfterExDate(t *testing.T) {
+	user := &User{
+		UserID: "U1",
+		Funds:  1000,
+		Portfolio: []*Asset{
+			{ID: "a1", Type: "long", Symbol: "AAPL", CostBasis: 400, Quantity: 10, Opened: "2026-10-30"},
+			{ID: "a2", Type: "long", Symbol: "AAPL", CostBasis: 100, Quantity: 5, Opened: "2026-11-02"},
+			{ID: "a3", Type: "long", Symbol: "MSFT", CostBasis: 300, Quantity: 2, Opened: "2026-10-30"},
+		},
+	}
+	action := CorporateAction{Type: ActionSplit, Symbol: "AAPL", Date: "2026-11-02", Ratio: 4}
+
+	applied, _, _ := user.applyCorporateAction(action, 100, SessionClosed)
+	if !applied {
+		t.Fatal("split wasn't applied to a lot from before the ex-date")
+	}
+
+	expected := map[string]struct{ quantity, basis float64 }{
+		"a1": {40, 100},
+		"a2": {5, 100},
+		"a3": {2, 300},
+	}
+	for _, asset := range user.Portfolio {
+		want := expected[asset.ID]
+		if asset.Quantity != want.quantity || asset.CostBasis != want.basis {
+			t.Errorf("lot %s: got %v shares at %v, want %v at %v", asset.ID, asset.Quantity, asset.CostBasis, want.quantity, want.basis)
+		}
+	}
+
+	if applied, _, _ := user.applyCorporateAction(action, 100, SessionClosed); applied {
+		t.Error("split was applied twice")
+	}
+}
+
+func TestCorporateActionsAfterExDateLeaveNewPositionsAlone(t *testing.T) {
+	actions := []CorporateAction{
+		{Type: ActionSplit, Symbol: "AAPL", Date: "2026-09-01", Ratio: 4},
+		{Type: ActionDividend, Symbol: "AAPL", Date: "2026-09-01", Amount: 0.5},
+	}
+
+	for _, action := range actions {
+		user := &User{
+			UserID: "U1",
+			Funds:  1000,
+			Portfolio: []*Asset{
+				{ID: "a1", Type: "long", Symbol: "AAPL", CostBasis: 100, Quantity: 10, Opened: "2026-10-15"},
+			},
+			Orders: []*Order{
+				{ID: "o1", Type: "limit_buy", Symbol: "AAPL", Quantity: 5, Target: 90, Status: OrderOpen, Created: time.Date(2026, 10, 14, 12, 0, 0, 0, calendar.Location)},
+			},
+		}
+
+		if user.affectedBy(action) {
+			t.Errorf("%s: user who bought after the ex-date is affected by it", action.Type)
+		}
+
+		applied, paid, adjusted := user.applyCorporateAction(action, 25, SessionClosed)
+		if applied || paid != 0 || len(adjusted) != 0 {
+			t.Errorf("%s: applied = %v, paid %v and adjusted %d orders, want nothing done", action.Type, applied, paid, len(adjusted))
+		}
+		if asset := user.Portfolio[0]; asset.Quantity != 10 || asset.CostBasis != 100 {
+			t.Errorf("%s: lot changed to %v shares at %v", action.Type, asset.Quantity, asset.CostBasis)
+		}
+		if order := user.Orders[0]; order.Quantity != 5 || order.Target != 90 {
+			t.Errorf("%s: order changed to %v shares at %v", action.Type, order.Quantity, order.Target)
+		}
+		if user.Funds != 1000 {
+			t.Errorf("%s: funds changed to %v", action.Type, user.Funds)
+		}
+	}
+}
+
+func TestDividendPaidOnLotsFromBeforeExDate(t *testing.T) {
+	user := &User{
+		UserID: "U1",
+		Portfolio: []*Asset{
+			{ID: "a1", Type: "long", Symbol: "MSFT", CostBasis: 300, Quantity: 10, Opened: "2026-11-18"},
+			{ID: "a2", Type: "long", Symbol: "MSFT", CostBasis: 300, Quantity: 10, Opened: "2026-11-19"},
+			{ID: "s1", Type: "short", Symbol: "MSFT", CostBasis: 300, Quantity: 4, Opened: "2026-11-18"},
+		},
+	}
+	action := CorporateAction{Type: ActionDividend, Symbol: "MSFT", Date: "2026-11-19", Amount: 0.5}
+
+	_, paid, _ := user.applyCorporateAction(action, 0, SessionClosed)
+	if paid != 3 || user.Funds != 3 {
+		t.Errorf("paid %v and funds are %v, want 5 paid on the old long less 2 owed on the short", paid, user.Funds)
+	}
+}
+
+func TestApplyCorporateActionWaitsForTradingToEnd(t *testing.T) {
+	defer func(saved Settings) { *settings = saved }(*settings)
+	saved, savedQuotes := Storage, quotes
+	t.Cleanup(func() { Storage, quotes = saved, savedQuotes })
+
+	tests := []struct {
+		name     string
+		extended bool
+		time     time.Time
+		err      error
+	}{
+		{name: "during the session", extended: true, time: exchangeTime(t, "2026-10-14", 11, 0), err: ErrTradingOpen},
+		{name: "during post market", extended: true, time: exchangeTime(t, "2026-10-14", 17, 0), err: ErrTradingOpen},
+		{name: "after post market", extended: true, time: exchangeTime(t, "2026-10-14", 20, 30)},
+		{name: "post market without extended hours", time: exchangeTime(t, "2026-10-14", 17, 0)},
+	}
+
+	for _, test := range tests {
+		settings.ExtendedHours = test.extended
+
+		feed, err := NewReplayFeed(writeReplayScript(t, "quotes.json", `[]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		quotes = feed
+
+		Storage = NewMemoryStore()
+		Storage.Create("U1", &User{
+			UserID:    "U1",
+			Portfolio: []*Asset{{ID: "a1", Type: "long", Symbol: "AAPL", CostBasis: 400, Quantity: 10, Opened: "2026-10-14"}},
+		})
+
+		action := CorporateAction{Type: ActionSplit, Symbol: "AAPL", Date: "2026-10-15", Ratio: 4}
+		if err := ApplyCorporateAction(action, test.time, DefaultSource(nil)); err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+
+		// Without a quote to pay fractional shares out at, the split waits for one.
+		if user, _ := Storage.Get("U1"); user.Portfolio[0].Quantity != 10 || len(user.CorporateActions) != 0 {
+			t.Errorf("%s: split was applied to %+v without a quote", test.name, *user.Portfolio[0])
+		}
+		if watching := feed.IsWatching("AAPL"); watching != (test.err == nil) {
+			t.Errorf("%s: watching AAPL is %v", test.name, watching)
+		}
+	}
+}
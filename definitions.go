@@ -4,16 +4,41 @@ import (
 	"encoding/json"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
 )
 
+// A Store persists user records and their ledgers. Update must apply the mutation
+// atomically with respect to every other writer of the same store.
+type Store interface {
+	Get(userID string) (*User, error)
+	Create(userID string, value *User) (bool, error)
+	Update(userID string, mutate func(user *User) error) (*User, error)
+	Delete(userID string, entries ...*LedgerEntry)
+	ForEach(callback func(user User))
+	GetAllUsers() []*User
+	AppendLedger(userID string, entries ...*LedgerEntry) error
+	GetLedger(userID string) ([]*LedgerEntry, error)
+}
+
+type MemoryStore struct {
+	users   map[string][]byte
+	ledgers map[string][]*LedgerEntry
+	mutex   *sync.Mutex
+}
+
+type FileStore struct {
+	*MemoryStore
+	path  string
+	mutex *sync.Mutex
+}
+
 type RedisClient struct {
 	client  *redis.Client
 	quit    chan struct{}
 	started bool
-	mutex   *sync.Mutex
 	prefix  string
 }
 
@@ -22,20 +47,45 @@ type RedisConfig struct {
 	Prefix   string
 }
 
+// A QuoteProvider supplies stock quotes, and notifies subscribers of updates to the
+// symbols they are interested in. Callbacks return whether they should be removed.
+type QuoteProvider interface {
+	GetQuote(symbol string, callback func(TradingViewQuote) (shouldDelete bool))
+	GetCurrent(symbol string) (quote TradingViewQuote, ok bool)
+	OnUpdate(symbol string, callback func(TradingViewQuote) (shouldDelete bool))
+	Watch(symbol string)
+}
+
+// A QuoteHub holds the latest quote of every watched symbol, along with the
+// subscribers to each symbol's updates, and is safe for concurrent use.
+type QuoteHub struct {
+	quotes      map[string]TradingViewQuote
+	subscribers map[string][]*TradingViewNotifications
+	mutex       *sync.RWMutex
+}
+
+type ReplayFeed struct {
+	*QuoteHub
+	frames   []json.RawMessage
+	position int
+	mutex    *sync.Mutex
+}
+
 type TradingView struct {
+	*QuoteHub
 	conn           *websocket.Conn
 	dialer         *websocket.Dialer
 	url            string
 	requestHeader  http.Header
-	OnConnected    func(tv TradingView)
-	OnConnectError func(err error, tv TradingView)
-	OnDisconnected func(err error, tv TradingView)
-	Watching       map[string]TradingViewQuote
-	notifications  []*TradingViewNotifications
-	IsConnected    bool
+	OnConnected    func(tv *TradingView)
+	OnConnectError func(err error, tv *TradingView)
+	OnDisconnected func(err error, tv *TradingView)
 	sessionID      string
 	sendMutex      *sync.Mutex
 	recvMutex      *sync.Mutex
+	state          string
+	stateChanged   time.Time
+	stateMutex     *sync.Mutex
 }
 
 type TradingViewNotifications struct {
@@ -76,4 +126,9 @@ type TradingViewQuote struct {
 	LivePrice            float64 `json:"rtc"`
 	LiveChange           float64 `json:"rch"`
 	LiveChangePercentage float64 `json:"rchp"`
+	Volume               float64 `json:"volume"`
+
+	// The volume traded since the previous update of the quote, as far as we've seen.
+	TradedVolume float64   `json:"-"`
+	ReceivedAt   time.Time `json:"-"`
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How long pending orders are good for. Orders without a time-in-force are good until
+// cancelled.
+const (
+	TimeInForceDay = "day"
+	TimeInForceGTC = "gtc"
+	TimeInForceGTD = "gtd"
+	TimeInForceIOC = "ioc"
+)
+
+// Returns whether the pending order has expired by the given time; orders expire once
+// the regular session of the last trading day they're good for has closed.
+func (o *Order) Expired(t time.Time) bool {
+	if o.Expires == "" {
+		return false
+	}
+
+	return o.Expires < calendar.TradingDate(t).Format(CALENDAR_DATE_FORMAT)
+}
+
+// Returns how long the pending order is good for, for messages to users.
+func (o *Order) TimeInForceDescription() string {
+	switch o.TimeInForce {
+	case TimeInForceDay:
+		return "day"
+	case TimeInForceGTD:
+		return "until " + o.Expires
+	case TimeInForceIOC:
+		return "immediate"
+	}
+
+	return "until cancelled"
+}
+
+// Expire the pending order, refunding any funds held for it. Orders that have already
+// been filled or cancelled are left alone.
+func (u *User) expireOrder(order *Order, reason string, source *Command) {
+	err := u.Update(func(user *User) (err error) {
+		_, err = user.cancelOrder(order.ID, LedgerExpire, calendar.Session(time.Now()))
+		return err
+	})
+
+	if err == ErrNoMatchingOrder {
+		return
+	} else if err != nil {
+		u.log(map[string]interface{}{
+			"method":   "expireOrder",
+			"order_id": order.ID,
+		}).WithError(err).Error("Unable to expire order.")
+		return
+	}
+
+	source.Say("<@%s>, your %s has expired %s. They have $%.2f funds available.", u.UserID, order.Description(), reason, u.Funds)
+}
+
+// Fill an immediate-or-cancel order against the current quote if it can be filled
+// right now, and expire it otherwise.
+func (u *User) FillOrExpireOrder(order *Order, source *Command) {
+	if quote, ok := quotes.GetCurrent(order.Symbol); ok && !quote.IsStale() && calendar.CanTrade(time.Now()) && orderTriggered(order, quote.Price()) {
+		u.executeOrder(order, quote, source)
+	}
+
+	u.expireOrder(order, "as it could not be filled immediately", source)
+}
+
+// Expire every pending order that is past its time-in-force, then do so again at each
+// close of the regular session. Expiring is safe to run from several bots at once, as
+// only one of them will find the order still there to expire.
+func ExpireOrders() {
+	for {
+		now := time.Now()
+		Storage.ForEach(func(user User) {
+			for i := range user.Orders {
+				order := user.Orders[i]
+				if order.Active() && order.Expired(now) {
+					user.expireOrder(order, "at the close of the market", DefaultSource(&user))
+				}
+			}
+		})
+
+		next := calendar.CloseOn(calendar.TradingDate(time.Now()))
+		log.WithField("next_run", calendar.Format(next)).Debug("Scheduled next order expiry run.")
+		time.Sleep(time.Until(next) + time.Second)
+	}
+}
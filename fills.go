@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+)
+
+// Returns the price a market order on the side fills at against the quote; the quoted
+// price, moved against the player by half the spread and by a share of the day's move
+// as a stand-in for the stock's volatility.
+func marketPrice(side string, quote TradingViewQuote) float64 {
+	price := quote.Price()
+	slippage := price * (settings.Spread/2 + settings.SlippageFactor*math.Abs(quote.ChangePercentage)/100)
+
+	switch side {
+	case "buy", "cover":
+		price = price + slippage
+	default:
+		price = price - slippage
+	}
+
+	return math.Round(price*10000) / 10000
+}
+
+// Returns the price the order fills at against the quote. Limit orders fill at their
+// limit price or better, everything else at the market.
+func fillPrice(order *Order, quote TradingViewQuote) float64 {
+	side := orderSide(order.Type)
+	price := marketPrice(side, quote)
+	if orderKind(order.Type) != "limit" {
+		return price
+	}
+
+	switch side {
+	case "buy", "cover":
+		return math.Min(price, order.Target)
+	}
+
+	return math.Max(price, order.Target)
+}
+
+// Returns how many of the remaining shares of a pending order fill against the quote.
+// Without a liquidity model the whole order fills at once; with one, it fills its share
+// of the volume traded since the previous update, in whole lots, and nothing at all on
+// an update without any. Market orders traded on the spot are exempt, and fill in full.
+func fillQuantity(remaining float64, quote TradingViewQuote) float64 {
+	if settings.Participation == 0 {
+		return remaining
+	}
+
+	available := math.Floor(settings.Participation * quote.TradedVolume)
+	available = available - math.Mod(available, float64(settings.LotSize))
+	if available > remaining {
+		return remaining
+	}
+
+	return available
+}
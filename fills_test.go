@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFillQuantity(t *testing.T) {
+	defer func(saved Settings) { *settings = saved }(*settings)
+
+	tests := []struct {
+		name          string
+		participation float64
+		lotSize       int
+		remaining     float64
+		volume        float64
+		want          float64
+	}{
+		{name: "no liquidity model", remaining: 500, want: 500},
+		{name: "no volume traded", participation: 0.1, lotSize: 1, remaining: 500, volume: 0, want: 0},
+		{name: "share of the volume", participation: 0.1, lotSize: 1, remaining: 500, volume: 1234, want: 123},
+		{name: "whole lots", participation: 0.1, lotSize: 100, remaining: 500, volume: 1234, want: 100},
+		{name: "less than a lot", participation: 0.1, lotSize: 100, remaining: 500, volume: 900, want: 0},
+		{name: "volume to spare", participation: 0.1, lotSize: 1, remaining: 2.5, volume: 1000000, want: 2.5},
+	}
+
+	for _, test := range tests {
+		settings.Participation = test.participation
+		settings.LotSize = test.lotSize
+		if got := fillQuantity(test.remaining, TradingViewQuote{TradedVolume: test.volume}); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExecuteOrderWaitsForVolume(t *testing.T) {
+	defer func(saved Settings) { *settings = saved }(*settings)
+	settings.Participation = 0.1
+	settings.LotSize = 1
+
+	saved := Storage
+	t.Cleanup(func() { Storage = saved })
+
+	Storage = NewMemoryStore()
+	user := &User{UserID: "U1", Funds: 9000, HeldFunds: 1000}
+	order := &Order{ID: "o1", Owner: "U1", Type: "limit_buy", Symbol: "AAPL", Quantity: 10, Target: 100, Status: OrderOpen}
+	user.Orders = []*Order{order}
+	Storage.Create("U1", user)
+
+	quote := TradingViewQuote{Symbol: "AAPL", LastPrice: 99, CurrentSession: SessionMarket}
+	if user.executeOrder(order, quote, nil) {
+		t.Error("watch deleted on an update without volume")
+	}
+
+	stored, _ := Storage.Get("U1")
+	if current := stored.Orders[0]; current.Filled != 0 || !current.Active() {
+		t.Errorf("order filled %v shares and is %s, want it left working", current.Filled, current.Status)
+	}
+}
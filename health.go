@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type HealthStatus struct {
+	Healthy bool   `json:"healthy"`
+	Quotes  string `json:"quotes"`
+	Since   string `json:"since,omitempty"`
+}
+
+// Report on the state of the quote feed; responds with a 503 while the TradingView
+// connection is down so orchestrators can tell a bot with frozen quotes from a healthy
+// one.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	status := HealthStatus{
+		Healthy: true,
+		Quotes:  "replay",
+	}
+
+	if tv, ok := quotes.(*TradingView); ok {
+		state, since := tv.State()
+		status.Healthy = state == TradingViewConnected
+		status.Quotes = state
+		status.Since = since.Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
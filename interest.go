@@ -0,0 +1,40 @@
+package main
+
+// Interest is paid on cash for every day of a 365 day year, and charged on margin debit
+// balances over a 360 day year, as brokers do.
+var (
+	CASH_INTEREST_DAYS_PER_YEAR   = 365.0
+	MARGIN_INTEREST_DAYS_PER_YEAR = 360.0
+)
+
+// Pay the user interest on their idle funds for the given number of days, or when
+// trading on margin with a negative balance, charge them interest on what they borrowed.
+// Funds held for pending orders don't earn interest, and neither do the proceeds of
+// shorts opened on margin; those are owed back when the shorts are covered.
+func (u *User) accrueInterest(days int, session string) (interest float64) {
+	switch cash := u.Funds - u.ShortProceeds(); {
+	case cash > 0:
+		interest = cash * settings.CashInterestRate / CASH_INTEREST_DAYS_PER_YEAR * float64(days)
+	case u.Funds < 0 && settings.Margin:
+		interest = u.Funds * settings.MarginInterestRate / MARGIN_INTEREST_DAYS_PER_YEAR * float64(days)
+	}
+
+	if interest == 0 {
+		return 0
+	}
+
+	u.log(map[string]interface{}{
+		"method":   "accrueInterest",
+		"days":     days,
+		"interest": interest,
+	}).Info("Accrued interest.")
+
+	u.Funds = u.Funds + interest
+	u.record(&LedgerEntry{
+		Event:   LedgerInterest,
+		Amount:  interest,
+		Session: session,
+	})
+
+	return interest
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInterestSkipsShortProceeds(t *testing.T) {
+	defer func(saved Settings) { *settings = saved }(*settings)
+	settings.Margin = true
+	settings.CashInterestRate = 0.0365
+	settings.MarginInterestRate = 0.036
+
+	tests := []struct {
+		name     string
+		funds    float64
+		proceeds float64
+		margin   bool
+		interest float64
+	}{
+		{name: "cash only", funds: 10000, interest: 10},
+		{name: "cash and short proceeds", funds: 60000, proceeds: 50000, margin: true, interest: 10},
+		{name: "short proceeds only", funds: 50000, proceeds: 50000, margin: true, interest: 0},
+		{name: "short outside of margin", funds: 10000, proceeds: 50000, interest: 10},
+		{name: "debit balance", funds: -10000, interest: -10},
+	}
+
+	for _, test := range tests {
+		user := &User{UserID: "U1", Funds: test.funds}
+		if test.proceeds != 0 {
+			user.Portfolio = []*Asset{
+				{ID: "s1", Type: "short", Symbol: "AAPL", CostBasis: 100, Quantity: test.proceeds / 100, Margin: test.margin},
+			}
+		}
+
+		interest := user.accrueInterest(10, SessionClosed)
+		if math.Abs(interest-test.interest) > 0.000001 {
+			t.Errorf("%s: got %v interest, want %v", test.name, interest, test.interest)
+		}
+	}
+}
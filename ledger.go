@@ -0,0 +1,290 @@
+package main
+
+import (
+	"time"
+)
+
+// Ledger events recorded against a user. Every change to a user's funds, held funds
+// or portfolio is recorded as one of these, so the account can be rebuilt from the
+// ledger alone.
+const (
+	LedgerOpen             = "open"
+	LedgerBuy              = "buy"
+	LedgerSell             = "sell"
+	LedgerShort            = "short"
+	LedgerCover            = "cover"
+	LedgerLimitPlace       = "limit_place"
+	LedgerLimitFill        = "limit_fill"
+	LedgerOrderPlace       = "order_place"
+	LedgerOrderFill        = "order_fill"
+	LedgerOrderPartialFill = "order_partial_fill"
+	LedgerOrderTrigger     = "order_trigger"
+	LedgerOrderAmend       = "order_amend"
+	LedgerRebase           = "rebase"
+	LedgerCancel           = "cancel"
+	LedgerExpire           = "expire"
+	LedgerLiquidation      = "liquidation"
+	LedgerMarginCall       = "margin_call"
+	LedgerBorrowFee        = "borrow_fee"
+	LedgerInterest         = "interest"
+	LedgerSplit            = "split"
+	LedgerDividend         = "dividend"
+	LedgerBankruptcy       = "bankruptcy"
+)
+
+type LedgerEntry struct {
+	Time      time.Time
+	Event     string
+	OrderID   string `json:",omitempty"`
+	LotID     string `json:",omitempty"`
+	Type      string
+	Symbol    string
+	Quantity  float64
+	Price     float64
+	Basis     float64
+	Limit     float64 `json:",omitempty"`
+	Amount    float64
+	Gain      float64
+	Held      float64
+	Funds     float64
+	HeldFunds float64
+	Session   string
+
+	Trail        float64 `json:",omitempty"`
+	TrailPercent bool    `json:",omitempty"`
+	TimeInForce  string  `json:",omitempty"`
+	Expires      string  `json:",omitempty"`
+	TakeProfit   float64 `json:",omitempty"`
+	StopLoss     float64 `json:",omitempty"`
+	Group        string  `json:",omitempty"`
+
+	Lots   []LotShares `json:",omitempty"`
+	Margin bool        `json:",omitempty"`
+	Ratio  float64     `json:",omitempty"`
+}
+
+// Queue a ledger entry against the user, stamping it with the current time and the
+// resulting balances. The store writes queued entries out along with the record, when
+// the user is created or the update that recorded them is applied.
+func (u *User) record(entry *LedgerEntry) {
+	entry.Time = time.Now()
+	entry.Funds = u.Funds
+	entry.HeldFunds = u.HeldFunds
+
+	u.ledger = append(u.ledger, entry)
+}
+
+// Returns whether the ledger event opens a new lot in the portfolio, as opposed to
+// reducing an existing one.
+func (e *LedgerEntry) opens() bool {
+	switch e.Event {
+	case LedgerBuy, LedgerShort, LedgerLimitPlace, LedgerOrderPlace:
+		return true
+	}
+
+	return false
+}
+
+// Rebuild a user's balances and portfolio by replaying their ledger from the start.
+// Bankruptcy wipes the slate clean; the following open event funds the new account.
+func ReplayLedger(userID string, entries []*LedgerEntry) *User {
+	user := &User{UserID: userID}
+
+	for i := range entries {
+		entry := entries[i]
+
+		switch entry.Event {
+		case LedgerBankruptcy:
+			user = &User{UserID: userID}
+			continue
+		case LedgerOpen:
+			user.Funds = user.Funds + entry.Amount
+			continue
+		}
+
+		user.Funds = user.Funds + entry.Amount
+		user.HeldFunds = user.HeldFunds + entry.Held
+		if entry.Gain != 0 {
+			user.realize(entry.Symbol, entry.Gain, entry.Time)
+		}
+
+		if isOrder(entry.Type) {
+			user.replayOrder(entry)
+			continue
+		}
+
+		if entry.Event == LedgerRebase {
+			if asset := user.findLot(entry.LotID); asset != nil {
+				asset.CostBasis = entry.Basis
+			}
+			continue
+		}
+
+		if entry.Event == LedgerInterest || entry.Event == LedgerDividend {
+			continue
+		}
+
+		if entry.Event == LedgerSplit {
+			var portfolio []*Asset
+			for j := range user.Portfolio {
+				asset := user.Portfolio[j]
+				if asset.ID == entry.LotID {
+					asset.Quantity = entry.Quantity
+					asset.CostBasis = entry.Basis
+				}
+				if asset.Quantity > 0 {
+					portfolio = append(portfolio, asset)
+				}
+			}
+			user.Portfolio = portfolio
+			continue
+		}
+
+		if entry.Event == LedgerBorrowFee {
+			if asset := user.findLot(entry.LotID); asset != nil {
+				asset.BorrowFees = asset.BorrowFees - entry.Amount
+				asset.FeesThrough = accrualDate(entry.Time).Format(CALENDAR_DATE_FORMAT)
+			}
+			continue
+		}
+
+		if entry.opens() {
+			asset := &Asset{
+				ID:        entry.LotID,
+				Margin:    entry.Margin,
+				Type:      entry.Type,
+				Symbol:    entry.Symbol,
+				CostBasis: entry.Basis,
+				Quantity:  entry.Quantity,
+				Opened:    calendar.TradingDate(entry.Time).Format(CALENDAR_DATE_FORMAT),
+			}
+			if asset.Type == "short" {
+				asset.FeesThrough = calendar.TradingDate(entry.Time).AddDate(0, 0, -1).Format(CALENDAR_DATE_FORMAT)
+			}
+			user.Portfolio = append(user.Portfolio, asset)
+			continue
+		}
+
+		remaining := entry.Quantity
+		var portfolio []*Asset
+		for j := range user.Portfolio {
+			asset := user.Portfolio[j]
+			matches := asset.CostBasis == entry.Basis
+			if entry.LotID != "" {
+				matches = asset.ID == entry.LotID
+			}
+			if remaining > 0 && asset.Type == entry.Type && asset.Symbol == entry.Symbol && matches {
+				closed := remaining
+				if asset.Quantity < closed {
+					closed = asset.Quantity
+				}
+				asset.Quantity = roundShares(asset.Quantity - closed)
+				remaining = roundShares(remaining - closed)
+			}
+
+			if asset.Quantity > 0 {
+				portfolio = append(portfolio, asset)
+			}
+		}
+		user.Portfolio = portfolio
+	}
+
+	return user
+}
+
+// Apply an order event from the ledger to the user's orders. Orders placed before they
+// had IDs of their own are matched on what is known about them instead.
+func (u *User) replayOrder(entry *LedgerEntry) {
+	if entry.opens() {
+		id := entry.OrderID
+		if id == "" {
+			id = u.legacyOrderID(entry.Type, entry.Symbol, entry.Quantity, entry.Basis)
+		}
+
+		u.Orders = append(u.Orders, &Order{
+			ID:           id,
+			Owner:        u.UserID,
+			Type:         entry.Type,
+			Symbol:       entry.Symbol,
+			Quantity:     entry.Quantity,
+			Target:       entry.Basis,
+			Limit:        entry.Limit,
+			Trail:        entry.Trail,
+			TrailPercent: entry.TrailPercent,
+			TimeInForce:  entry.TimeInForce,
+			Expires:      entry.Expires,
+			TakeProfit:   entry.TakeProfit,
+			StopLoss:     entry.StopLoss,
+			Group:        entry.Group,
+			Status:       OrderOpen,
+			Created:      entry.Time,
+			Updated:      entry.Time,
+		})
+
+		order := u.Orders[len(u.Orders)-1]
+		if order.ReservesShares() {
+			u.reserveLots(order, entry.Lots, order.Quantity)
+		}
+		return
+	}
+
+	var order *Order
+	if entry.OrderID != "" {
+		order = u.activeOrder(entry.OrderID)
+	} else {
+		for i := range u.Orders {
+			candidate := u.Orders[i]
+			if candidate.Active() && candidate.Type == entry.Type && candidate.Symbol == entry.Symbol && candidate.Target == entry.Basis {
+				order = candidate
+				break
+			}
+		}
+	}
+	if order == nil {
+		return
+	}
+
+	order.Updated = entry.Time
+	switch entry.Event {
+	case LedgerOrderTrigger:
+		order.Type = entry.Type
+		order.Target = entry.Basis
+		order.Limit = 0
+		return
+	case LedgerOrderAmend:
+		order.Quantity = entry.Quantity
+		order.Target = entry.Basis
+		order.Limit = entry.Limit
+		if order.ReservesShares() {
+			u.release(order, order.Reserved())
+			u.reserveLots(order, entry.Lots, order.Remaining())
+		}
+		return
+	case LedgerSplit:
+		u.release(order, order.Reserved())
+		order.adjustForSplit(entry.Ratio)
+		order.Quantity = entry.Quantity
+		if order.ReservesShares() {
+			u.reserveLots(order, entry.Lots, order.Remaining())
+		}
+		return
+	case LedgerDividend:
+		order.Target = entry.Basis
+		order.Limit = entry.Limit
+		return
+	case LedgerOrderPartialFill:
+		u.release(order, entry.Quantity)
+		order.FillPrice = (order.FillPrice*order.Filled + entry.Price*entry.Quantity) / (order.Filled + entry.Quantity)
+		order.Filled = roundShares(order.Filled + entry.Quantity)
+		order.Status = OrderPartiallyFilled
+		return
+	}
+
+	u.release(order, order.Reserved())
+	order.Status = finishedStatus(entry.Event)
+	if order.Status == OrderFilled {
+		order.FillPrice = (order.FillPrice*order.Filled + entry.Price*entry.Quantity) / order.Quantity
+		order.Filled = order.Quantity
+	}
+	u.pruneOrders()
+}
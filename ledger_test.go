@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayLedgerRebuildsUser(t *testing.T) {
+	defer func(saved Settings) { *settings = saved }(*settings)
+	settings.BorrowRate = 0.036
+	settings.CashInterestRate = 0.0365
+	settings.Participation = 0
+
+	saved, savedQuotes := Storage, quotes
+	t.Cleanup(func() { Storage, quotes = saved, savedQuotes })
+
+	script := filepath.Join(t.TempDir(), "quotes.json")
+	if err := ioutil.WriteFile(script, []byte(`[
+		{"short_name": "AAPL", "lp": 120, "current_session": "market"},
+		{"short_name": "MSFT", "lp": 300, "current_session": "market"}
+	]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := NewReplayFeed(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for feed.Step() {
+	}
+	quotes = feed
+
+	Storage = NewMemoryStore()
+	user := &User{UserID: "U1", Funds: DEFAULT_WALLET_VALUE}
+	user.record(&LedgerEntry{Event: LedgerOpen, Amount: DEFAULT_WALLET_VALUE})
+	if _, err := Storage.Create("U1", user); err != nil {
+		t.Fatal(err)
+	}
+
+	exDate := calendar.TradingDate(time.Now()).AddDate(0, 0, 7).Format(CALENDAR_DATE_FORMAT)
+	quote := func(symbol string) TradingViewQuote {
+		quote, _ := quotes.GetCurrent(symbol)
+		return quote
+	}
+
+	var sell, cancel, buy, cover *Order
+	steps := []struct {
+		name   string
+		mutate func(user *User) error
+	}{
+		{"buy", func(user *User) (err error) {
+			if _, err = user.open("long", "AAPL", 10, 100, SessionMarket); err != nil {
+				return err
+			}
+			_, err = user.open("long", "AAPL", 5.5, 110, SessionMarket)
+			return err
+		}},
+		{"sell", func(user *User) (err error) {
+			_, _, _, err = user.close(LedgerSell, "long", "AAPL", 3, "", 0, 120, SessionMarket)
+			return err
+		}},
+		{"short", func(user *User) (err error) {
+			_, err = user.open("short", "MSFT", 4, 300, SessionMarket)
+			return err
+		}},
+		{"limit sell", func(user *User) error {
+			sell = &Order{Type: "limit_sell", Symbol: "AAPL", Quantity: 6, Target: 118}
+			return user.placeOrder(sell, SessionMarket)
+		}},
+		{"partial fill", func(user *User) (err error) {
+			settings.Participation = 0.5
+			defer func() { settings.Participation = 0 }()
+			partial := quote("AAPL")
+			partial.TradedVolume = 8
+			_, err = user.fillOrderAt(sell.ID, partial, 120, SessionMarket)
+			return err
+		}},
+		{"fill", func(user *User) (err error) {
+			_, err = user.fillOrderAt(sell.ID, quote("AAPL"), 121, SessionMarket)
+			return err
+		}},
+		{"limit buy to cancel", func(user *User) error {
+			cancel = &Order{Type: "limit_buy", Symbol: "AAPL", Quantity: 5, Target: 90}
+			return user.placeOrder(cancel, SessionMarket)
+		}},
+		{"cancel", func(user *User) (err error) {
+			_, err = user.cancelOrder(cancel.ID, LedgerCancel, SessionMarket)
+			return err
+		}},
+		{"pending orders", func(user *User) error {
+			buy = &Order{Type: "limit_buy", Symbol: "AAPL", Quantity: 3, Target: 95}
+			if err := user.placeOrder(buy, SessionMarket); err != nil {
+				return err
+			}
+			if err := user.placeOrder(&Order{Type: "limit_sell", Symbol: "AAPL", Quantity: 2.5, Target: 140}, SessionMarket); err != nil {
+				return err
+			}
+			cover = &Order{Type: "limit_cover", Symbol: "MSFT", Quantity: 3, Target: 250}
+			return user.placeOrder(cover, SessionMarket)
+		}},
+		{"amend", func(user *User) (err error) {
+			_, err = user.amendOrder(buy.ID, 4, 96, SessionMarket)
+			return err
+		}},
+		{"split", func(user *User) error {
+			action := CorporateAction{Type: ActionSplit, Symbol: "AAPL", Date: exDate, Ratio: 1.5}
+			if applied, _, _ := user.applyCorporateAction(action, 80, SessionClosed); !applied {
+				t.Error("split wasn't applied")
+			}
+			return nil
+		}},
+		{"dividend", func(user *User) error {
+			for _, symbol := range []string{"AAPL", "MSFT"} {
+				action := CorporateAction{Type: ActionDividend, Symbol: symbol, Date: exDate, Amount: 0.25}
+				if applied, _, _ := user.applyCorporateAction(action, 0, SessionClosed); !applied {
+					t.Errorf("%s dividend wasn't applied", symbol)
+				}
+			}
+			return nil
+		}},
+		{"borrow fees", func(user *User) error {
+			// As if the short had been held for a few days.
+			through := accrualDate(time.Now())
+			for _, asset := range user.Portfolio {
+				if asset.Type == "short" {
+					asset.FeesThrough = through.AddDate(0, 0, -3).Format(CALENDAR_DATE_FORMAT)
+				}
+			}
+			if charged := user.accrueBorrowFees(through, SessionClosed); charged == 0 {
+				t.Error("no borrow fees were charged")
+			}
+			return nil
+		}},
+		{"interest", func(user *User) error {
+			user.accrueInterest(3, SessionClosed)
+			return nil
+		}},
+		{"cover", func(user *User) (err error) {
+			_, err = user.fillOrderAt(cover.ID, quote("MSFT"), 250, SessionMarket)
+			return err
+		}},
+	}
+
+	for _, step := range steps {
+		if err := user.Update(step.mutate); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+	}
+
+	stored, _ := Storage.Get("U1")
+	entries, _ := Storage.GetLedger("U1")
+	replayed := ReplayLedger("U1", entries)
+
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 0.000001
+	}
+
+	if !near(stored.Funds, replayed.Funds) || !near(stored.HeldFunds, replayed.HeldFunds) {
+		t.Errorf("funds: stored %v (%v held), replayed %v (%v held)", stored.Funds, stored.HeldFunds, replayed.Funds, replayed.HeldFunds)
+	}
+	if !near(stored.RealizedGains, replayed.RealizedGains) {
+		t.Errorf("realized gains: stored %v, replayed %v", stored.RealizedGains, replayed.RealizedGains)
+	}
+	for symbol, gains := range stored.RealizedBySymbol {
+		if !near(gains, replayed.RealizedBySymbol[symbol]) {
+			t.Errorf("realized gains on %s: stored %v, replayed %v", symbol, gains, replayed.RealizedBySymbol[symbol])
+		}
+	}
+
+	if len(stored.Portfolio) != len(replayed.Portfolio) {
+		t.Fatalf("portfolio: stored %d lots, replayed %d", len(stored.Portfolio), len(replayed.Portfolio))
+	}
+	for i := range stored.Portfolio {
+		a, b := *stored.Portfolio[i], *replayed.Portfolio[i]
+		if !near(a.CostBasis, b.CostBasis) || !near(a.BorrowFees, b.BorrowFees) {
+			t.Errorf("lot %s: stored %+v, replayed %+v", a.ID, a, b)
+		}
+		a.CostBasis, b.CostBasis, a.BorrowFees, b.BorrowFees = 0, 0, 0, 0
+		if a != b {
+			t.Errorf("lot %s: stored %+v, replayed %+v", a.ID, a, b)
+		}
+	}
+
+	if len(stored.Orders) != len(replayed.Orders) {
+		t.Fatalf("orders: stored %d, replayed %d", len(stored.Orders), len(replayed.Orders))
+	}
+	for i := range stored.Orders {
+		a, b := stored.Orders[i], replayed.Orders[i]
+		if a.ID != b.ID || a.Type != b.Type || a.Status != b.Status || a.Quantity != b.Quantity || a.Filled != b.Filled ||
+			!near(a.Target, b.Target) || !near(a.FillPrice, b.FillPrice) || a.Reserved() != b.Reserved() {
+			t.Errorf("order %s: stored %+v, replayed %+v", a.ID, *a, *b)
+		}
+	}
+}
+
+func TestLegacyOrderIDsMatchReplay(t *testing.T) {
+	// Pending orders from before they had a model of their own, stored in the portfolio
+	// and placed in the ledger without IDs.
+	var stored User
+	if err := json.Unmarshal([]byte(`{"UserID": "U1", "Portfolio": [
+		{"Type": "limit_buy", "Symbol": "AAPL", "Quantity": 10, "CostBasis": 90},
+		{"Type": "long", "Symbol": "MSFT", "Quantity": 1, "CostBasis": 300},
+		{"Type": "limit_buy", "Symbol": "AAPL", "Quantity": 10, "CostBasis": 90},
+		{"Type": "limit_sell", "Symbol": "MSFT", "Quantity": 1, "CostBasis": 350}
+	]}`), &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2026, 3, 2, 10, 0, 0, 0, calendar.Location)
+	replayed := ReplayLedger("U1", []*LedgerEntry{
+		{Event: LedgerLimitPlace, Type: "limit_buy", Symbol: "AAPL", Quantity: 10, Basis: 90, Time: start},
+		{Event: LedgerBuy, Type: "long", Symbol: "MSFT", Quantity: 1, Basis: 300, Time: start.Add(time.Hour)},
+		{Event: LedgerLimitPlace, Type: "limit_buy", Symbol: "AAPL", Quantity: 10, Basis: 90, Time: start.Add(2 * time.Hour)},
+		{Event: LedgerLimitPlace, Type: "limit_sell", Symbol: "MSFT", Quantity: 1, Basis: 350, Time: start.Add(3 * time.Hour)},
+	})
+
+	if len(stored.Orders) != 3 || len(replayed.Orders) != 3 {
+		t.Fatalf("got %d stored and %d replayed orders, want 3", len(stored.Orders), len(replayed.Orders))
+	}
+	for i := range stored.Orders {
+		if stored.Orders[i].ID != replayed.Orders[i].ID {
+			t.Errorf("order %d: stored as %s, replayed as %s", i, stored.Orders[i].ID, replayed.Orders[i].ID)
+		}
+	}
+	if stored.Orders[0].ID == stored.Orders[1].ID {
+		t.Errorf("identical orders both got ID %s", stored.Orders[0].ID)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Lot relief methods, deciding which lots of a position are closed first when it is
+// only partly closed. With average cost, all lots of the position are brought to their
+// average cost basis whenever any of it is closed.
+const (
+	LotsFIFO    = "fifo"
+	LotsLIFO    = "lifo"
+	LotsHIFO    = "hifo"
+	LotsAverage = "average"
+)
+
+var LotMethods = []string{LotsFIFO, LotsLIFO, LotsHIFO, LotsAverage}
+
+// Shares of a lot; those reserved for an order, or closed by a trade.
+type LotShares struct {
+	ID       string `json:",omitempty"`
+	Basis    float64
+	Quantity float64
+}
+
+// Returns a new short ID for a lot, unique among the user's lots.
+func (u *User) newLotID() string {
+	for {
+		id := make([]byte, 2)
+		rand.Read(id)
+		if u.findLot(hex.EncodeToString(id)) == nil {
+			return hex.EncodeToString(id)
+		}
+	}
+}
+
+// Returns the user's lot with the specified ID, if any.
+func (u *User) findLot(id string) *Asset {
+	for i := range u.Portfolio {
+		if strings.EqualFold(u.Portfolio[i].ID, id) {
+			return u.Portfolio[i]
+		}
+	}
+
+	return nil
+}
+
+// Returns the lot relief method of the user; first in, first out unless they picked
+// another.
+func (u *User) lotMethod() string {
+	if u.LotMethod == "" {
+		return LotsFIFO
+	}
+
+	return u.LotMethod
+}
+
+// Returns the lots of the position in the order the user's lot relief method closes
+// them; the oldest first, the newest first, or the highest cost basis first. Positions
+// at average cost close their oldest lots first, as all are at the same cost basis.
+func (u *User) positionLots(position_type string, symbol string) (lots []*Asset) {
+	for i := range u.Portfolio {
+		if u.Portfolio[i].Type == position_type && u.Portfolio[i].Symbol == symbol {
+			lots = append(lots, u.Portfolio[i])
+		}
+	}
+
+	switch u.lotMethod() {
+	case LotsLIFO:
+		for i, j := 0, len(lots)-1; i < j; i, j = i+1, j-1 {
+			lots[i], lots[j] = lots[j], lots[i]
+		}
+	case LotsHIFO:
+		sort.SliceStable(lots, func(i, j int) bool {
+			return lots[i].CostBasis > lots[j].CostBasis
+		})
+	}
+
+	return lots
+}
+
+// Bring every lot of the position to the average cost basis of the position, recording
+// each lot changed in the ledger. The cost of the position as a whole stays the same.
+func (u *User) averageLots(position_type string, symbol string, session string) {
+	lots := u.positionLots(position_type, symbol)
+
+	var cost float64
+	var quantity float64
+	for i := range lots {
+		cost = cost + lots[i].CostBasis*lots[i].Quantity
+		quantity = roundShares(quantity + lots[i].Quantity)
+	}
+	if quantity == 0 {
+		return
+	}
+
+	average := cost / quantity
+	for i := range lots {
+		if math.Abs(lots[i].CostBasis-average) < 0.000001 {
+			continue
+		}
+
+		lots[i].CostBasis = average
+		u.record(&LedgerEntry{
+			Event:    LedgerRebase,
+			LotID:    lots[i].ID,
+			Type:     lots[i].Type,
+			Symbol:   lots[i].Symbol,
+			Quantity: lots[i].Quantity,
+			Price:    average,
+			Basis:    average,
+			Session:  session,
+		})
+	}
+}
+
+// Describe the lot relief method, as in "closes their lots ...".
+func lotMethodDescription(method string) string {
+	switch method {
+	case LotsLIFO:
+		return "newest first (LIFO)"
+	case LotsHIFO:
+		return "highest price paid first (HIFO)"
+	case LotsAverage:
+		return "at the average price paid across the position"
+	}
+
+	return "oldest first (FIFO)"
+}
+
+// Returns the number of shares across the lots.
+func sharesIn(lots []LotShares) (quantity float64) {
+	for i := range lots {
+		quantity = roundShares(quantity + lots[i].Quantity)
+	}
+
+	return quantity
+}
+
+// Describe the lots shares were closed from, for confirmations.
+func describeLots(lots []LotShares) string {
+	var described []string
+	for i := range lots {
+		described = append(described, fmt.Sprintf("`%s` (%s at $%.2f)", lots[i].ID, formatShares(lots[i].Quantity), lots[i].Basis))
+	}
+
+	if len(described) == 1 {
+		return "lot " + described[0]
+	}
+
+	return "lots " + strings.Join(described, ", ")
+}
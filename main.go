@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
@@ -10,7 +12,7 @@ import (
 	"github.com/slack-go/slack/slackevents"
 )
 
-var tradingview = NewTradingView()
+var quotes QuoteProvider
 
 func main() {
 	log.SetFormatter(&log.TextFormatter{
@@ -18,11 +20,10 @@ func main() {
 	})
 	log.SetLevel(log.DebugLevel)
 
-	InitRedis(RedisConfig{
-		RedisURL: os.Getenv("REDIS_URL"),
-		Prefix:   os.Getenv("REDIS_KEY_PREFIX"),
-	})
-	go Redis.Start()
+	InitStorage()
+	if redis, ok := Storage.(*RedisClient); ok {
+		go redis.Start()
+	}
 
 	slack := &http.Server{
 		Handler:      SlackEventRouter(),
@@ -32,25 +33,68 @@ func main() {
 	}
 
 	//InitWatchList()
-	tradingview.OnConnected = func(tv TradingView) {
-		Redis.ForEach(func(user User) {
-			source := Command{
-				Event: &slackevents.MessageEvent{
-					Channel: os.Getenv("SLACK_DEFAULT_CHANNEL"),
-				},
-				User: &user,
-			}
-			for i := range user.Portfolio {
-				asset := user.Portfolio[i]
-				tv.Watch(asset.Symbol)
-
-				if asset.Type == "limit_buy" || asset.Type == "limit_sell" {
-					user.WatchLimitOrder(asset, &source)
-				}
-			}
-		})
+	switch strings.ToLower(os.Getenv("QUOTE_PROVIDER")) {
+	case "replay":
+		feed, err := NewReplayFeed(os.Getenv("QUOTE_REPLAY_FILE"))
+		if err != nil {
+			log.Fatalf("Unable to load quote replay file: %v", err)
+		}
+
+		interval, err := time.ParseDuration(os.Getenv("QUOTE_REPLAY_INTERVAL"))
+		if err != nil {
+			interval = 1 * time.Second
+		}
+
+		quotes = feed
+		RestoreWatches()
+		go feed.Play(interval)
+	default:
+		// Watches and notifications live on across reconnects, so they only need
+		// restoring from storage the first time we connect.
+		var restore sync.Once
+		tradingview := NewTradingView()
+		tradingview.OnConnected = func(tv *TradingView) {
+			restore.Do(RestoreWatches)
+		}
+
+		quotes = tradingview
+		go tradingview.Connect()
 	}
-	go tradingview.Connect()
+
+	// Expiring orders and the daily jobs look up quotes, so they can only start once
+	// there's a quote provider to ask. Quotes may still be on their way; anything that
+	// needs one it doesn't have yet is left for a later run.
+	go ExpireOrders()
+	go RunScheduler()
 
 	log.Fatal(slack.ListenAndServe())
 }
+
+// Returns a command replying in the default channel on behalf of the user, for notices
+// that aren't in response to one of their messages.
+func DefaultSource(user *User) *Command {
+	return &Command{
+		Event: &slackevents.MessageEvent{
+			Channel: os.Getenv("SLACK_DEFAULT_CHANNEL"),
+		},
+		User: user,
+	}
+}
+
+// Watch every symbol held by a player, along with their margin on it, and re-arm the
+// watchers for their pending orders.
+func RestoreWatches() {
+	Storage.ForEach(func(user User) {
+		source := DefaultSource(&user)
+		for i := range user.Portfolio {
+			quotes.Watch(user.Portfolio[i].Symbol)
+			user.WatchMargin(user.Portfolio[i].Symbol)
+		}
+
+		orders := user.ActiveOrders()
+		for i := range orders {
+			quotes.Watch(orders[i].Symbol)
+			user.WatchOrder(orders[i], source)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How long after a margin call another one may be issued to the same player, giving the
+// liquidation time to go through.
+var MARGIN_CALL_INTERVAL = time.Minute
+
+var marginState = struct {
+	mutex    sync.Mutex
+	watching map[string]bool
+	calls    map[string]time.Time
+}{
+	watching: map[string]bool{},
+	calls:    map[string]time.Time{},
+}
+
+// Returns the market value of the lot at the latest quote, or at its cost basis while
+// there is no quote for it.
+func (a *Asset) MarketValue() float64 {
+	price := a.CostBasis
+	if quote, ok := quotes.GetCurrent(a.Symbol); ok && quote.LastPrice != 0 {
+		price = quote.LastPrice
+	}
+
+	return price * a.Quantity
+}
+
+// Returns the user's equity; their funds, including those held for orders, and their
+// long positions, less what it takes to buy back shorts they were credited for. Shorts
+// opened outside of margin had their value set aside, and are worth that plus their
+// gains.
+func (u *User) Equity() float64 {
+	equity := u.Funds + u.HeldFunds
+	for i := range u.Portfolio {
+		asset := u.Portfolio[i]
+		switch {
+		case asset.Type == "long":
+			equity = equity + asset.MarketValue()
+		case asset.Margin:
+			equity = equity - asset.MarketValue()
+		default:
+			equity = equity + 2*asset.CostBasis*asset.Quantity - asset.MarketValue()
+		}
+	}
+
+	return equity
+}
+
+// Returns the value of the user's positions, longs and shorts alike, that margin
+// requirements are based on.
+func (u *User) PositionValue() (value float64) {
+	for i := range u.Portfolio {
+		value = value + u.Portfolio[i].MarketValue()
+	}
+
+	return value
+}
+
+// Returns the proceeds of the user's shorts opened on margin; credited to their funds,
+// but owed back when the shorts are covered.
+func (u *User) ShortProceeds() (proceeds float64) {
+	for i := range u.Portfolio {
+		if asset := u.Portfolio[i]; asset.Type == "short" && asset.Margin {
+			proceeds = proceeds + asset.CostBasis*asset.Quantity
+		}
+	}
+
+	return proceeds
+}
+
+// Returns the equity the user needs to keep their positions open.
+func (u *User) MaintenanceRequirement() float64 {
+	return settings.MaintenanceMargin * u.PositionValue()
+}
+
+// Returns the value of the positions the user can still open on margin. Funds held for
+// orders count as positions already, as they will be once filled.
+func (u *User) BuyingPower() float64 {
+	excess := u.Equity() - settings.InitialMargin*(u.PositionValue()+u.HeldFunds)
+	return math.Max(0, excess/settings.InitialMargin)
+}
+
+// Returns what the user has available to trade with; their buying power when trading on
+// margin, otherwise their funds.
+func (u *User) available() float64 {
+	if settings.Margin {
+		return u.BuyingPower()
+	}
+
+	return u.Funds
+}
+
+// Returns whether the user holds a position, long or short, in the symbol.
+func (u *User) holds(symbol string) bool {
+	for i := range u.Portfolio {
+		if u.Portfolio[i].Symbol == symbol {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watch the user's margin on every update of the symbol for as long as they hold it,
+// issuing a margin call when their equity drops below the maintenance requirement. Only
+// one watch is kept per player and symbol.
+func (u *User) WatchMargin(symbol string) {
+	if !settings.Margin {
+		return
+	}
+
+	key := u.UserID + ":" + symbol
+	marginState.mutex.Lock()
+	if marginState.watching[key] {
+		marginState.mutex.Unlock()
+		return
+	}
+	marginState.watching[key] = true
+	marginState.mutex.Unlock()
+
+	userID := u.UserID
+	quotes.OnUpdate(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
+		user, err := Storage.Get(userID)
+		if err != nil {
+			return false
+		}
+
+		if !user.holds(symbol) {
+			marginState.mutex.Lock()
+			delete(marginState.watching, key)
+			marginState.mutex.Unlock()
+			return true
+		}
+
+		if quote.IsStale() || !calendar.CanTrade(time.Now()) {
+			return false
+		}
+
+		user.CheckMargin(DefaultSource(user))
+		return false
+	})
+}
+
+// Issue a margin call if the user's equity is below the maintenance requirement; their
+// orders are cancelled, and their largest positions liquidated until the requirement
+// is met again.
+func (u *User) CheckMargin(source *Command) {
+	equity := u.Equity()
+	required := u.MaintenanceRequirement()
+	if equity >= required {
+		return
+	}
+
+	marginState.mutex.Lock()
+	if time.Since(marginState.calls[u.UserID]) < MARGIN_CALL_INTERVAL {
+		marginState.mutex.Unlock()
+		return
+	}
+	marginState.calls[u.UserID] = time.Now()
+	marginState.mutex.Unlock()
+
+	log := u.log(map[string]interface{}{
+		"method":      "CheckMargin",
+		"equity":      equity,
+		"requirement": required,
+	})
+	log.Warn("Equity below maintenance requirement; issuing margin call.")
+	source.Say("<@%s>, margin call! Your equity of $%.2f is below the maintenance requirement of $%.2f. Your orders are being cancelled, and positions liquidated to bring your account back in line.", u.UserID, equity, required)
+
+	err := u.Update(func(user *User) error {
+		orders := user.ActiveOrders()
+		for i := range orders {
+			user.cancelOrder(orders[i].ID, LedgerMarginCall, calendar.Session(time.Now()))
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Unable to cancel orders for margin call.")
+	}
+
+	// Closing a position frees up the maintenance margin on its value; liquidate the
+	// largest lots until that makes up the shortfall. Without any equity left,
+	// everything goes.
+	shortfall := (u.MaintenanceRequirement() - u.Equity()) / settings.MaintenanceMargin
+	if u.Equity() <= 0 {
+		shortfall = math.Inf(1)
+	}
+
+	lots := append([]*Asset{}, u.Portfolio...)
+	sort.SliceStable(lots, func(i, j int) bool {
+		return lots[i].MarketValue() > lots[j].MarketValue()
+	})
+
+	for i := range lots {
+		if shortfall <= 0 {
+			break
+		}
+
+		asset := lots[i]
+		quantity := asset.Quantity
+		if price := asset.MarketValue() / asset.Quantity; shortfall < asset.MarketValue() {
+			quantity = math.Min(asset.Quantity, ceilShares(shortfall/price))
+		}
+		shortfall = shortfall - asset.MarketValue()*quantity/asset.Quantity
+
+		log.WithFields(map[string]interface{}{
+			"lot":      asset.ID,
+			"symbol":   asset.Symbol,
+			"quantity": quantity,
+		}).Info("Liquidating for margin call.")
+		u.closePosition(LedgerMarginCall, asset.Type, asset.Symbol, quantity, asset.ID, 0, source)
+	}
+}
@@ -0,0 +1,1495 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// Pending orders are kept on the user apart from their portfolio, with a type of
+// `kind_side`; the kind decides when the order triggers (`limit` at a target price,
+// `open` on the next regular session, `stop` and `stoplimit` when the price moves past
+// a stop price, and `trail` when the price falls back from its high by the trail
+// amount), and the side what it does once triggered (`buy`, `sell`, `short` or
+// `cover`).
+
+// Order statuses.
+const (
+	OrderOpen            = "open"
+	OrderPartiallyFilled = "partially_filled"
+	OrderFilled          = "filled"
+	OrderCancelled       = "cancelled"
+	OrderExpired         = "expired"
+)
+
+// How many filled, cancelled and expired orders are kept on the user for reference.
+var ORDER_HISTORY_SIZE = 20
+
+var ErrNoMatchingOrder = errors.New("no matching order")
+var ErrInvalidAmendment = errors.New("invalid amendment")
+var ErrBracketPrices = errors.New("bracket prices out of order")
+var ErrInsufficientShares = errors.New("insufficient unreserved shares")
+var ErrInvalidPrice = errors.New("invalid order price")
+
+type Order struct {
+	ID       string
+	Owner    string
+	Type     string
+	Symbol   string
+	Quantity float64
+	Filled   float64 `json:",omitempty"`
+	Target   float64 `json:",omitempty"`
+	Limit    float64 `json:",omitempty"`
+
+	// Trailing stop orders trail the best price seen since they were placed by either
+	// a dollar amount or, if TrailPercent is set, a percentage of that price.
+	Trail        float64 `json:",omitempty"`
+	TrailPercent bool    `json:",omitempty"`
+	HighWater    float64 `json:",omitempty"`
+
+	// Orders with a time-in-force other than good-till-cancelled expire after the close
+	// of the trading day in Expires.
+	TimeInForce string `json:",omitempty"`
+	Expires     string `json:",omitempty"`
+
+	// Bracket orders place a take-profit and a stop-loss order once filled. The two
+	// share the ID of the bracket as their group; filling either cancels the other.
+	TakeProfit float64 `json:",omitempty"`
+	StopLoss   float64 `json:",omitempty"`
+	Group      string  `json:",omitempty"`
+
+	// Limit orders to sell or cover reserve the shares they close from specific lots of
+	// the position when placed, so the same shares can't be closed twice.
+	Lots []LotShares `json:",omitempty"`
+
+	// The average price the order has been filled at.
+	FillPrice float64 `json:",omitempty"`
+
+	Status  string
+	Created time.Time
+	Updated time.Time
+}
+
+// Returns whether the order is still waiting to be filled.
+func (o *Order) Active() bool {
+	return o.Status == OrderOpen || o.Status == OrderPartiallyFilled
+}
+
+// Returns the number of shares still to be filled.
+func (o *Order) Remaining() float64 {
+	return roundShares(o.Quantity - o.Filled)
+}
+
+// Returns the price per share that funds are held at for the order; the limit price
+// for stop-limit orders, otherwise the target price.
+func (o *Order) HoldPrice() float64 {
+	if o.Limit != 0 {
+		return o.Limit
+	}
+
+	return o.Target
+}
+
+// Returns whether funds are held to cover the order while it waits to trigger. The legs
+// of a bracket close a position the player already holds, so hold nothing.
+func (o *Order) HoldsFunds() bool {
+	return holdsFunds(o.Type) && o.Group == ""
+}
+
+// Returns whether shares are reserved from the position for the order while it waits to
+// trigger. The legs of a bracket are left to close whatever is left of the position, so
+// reserve nothing.
+func (o *Order) ReservesShares() bool {
+	return reservesShares(o.Type) && o.Group == ""
+}
+
+// Returns the number of shares currently reserved for the order.
+func (o *Order) Reserved() (quantity float64) {
+	for i := range o.Lots {
+		quantity = roundShares(quantity + o.Lots[i].Quantity)
+	}
+
+	return quantity
+}
+
+// Returns the current stop price of a trailing stop order, trailing the high-water
+// mark (or the placement price, if no high-water mark has been recorded).
+func (o *Order) TrailStop() float64 {
+	high := o.HighWater
+	if high == 0 {
+		high = o.Target
+	}
+
+	if o.TrailPercent {
+		return high * (1 - o.Trail/100)
+	}
+
+	return high - o.Trail
+}
+
+// Returns the trail amount of a trailing stop order, for messages to users.
+func (o *Order) TrailDescription() string {
+	if o.TrailPercent {
+		return format.Sprintf("%g%%", o.Trail)
+	}
+
+	return format.Sprintf("$%.2f", o.Trail)
+}
+
+// Returns a description of the order, for messages to users.
+func (o *Order) Description() string {
+	return fmt.Sprintf("%s order `%s` to %s %s of %s", orderKindName(o.Type), o.ID, orderSide(o.Type), formatShares(o.Quantity), o.Symbol)
+}
+
+// Returns whether the order has the same terms as the other; watches are deleted once
+// the order they were started for has been amended.
+func (o *Order) sameTerms(other *Order) bool {
+	return o.Type == other.Type && o.Quantity == other.Quantity && o.Target == other.Target && o.Limit == other.Limit
+}
+
+// Returns whether the asset type is a pending order rather than a held position.
+func isOrder(position_type string) bool {
+	return strings.Contains(position_type, "_")
+}
+
+// Returns the kind of pending order of the asset type.
+func orderKind(position_type string) string {
+	return strings.SplitN(position_type, "_", 2)[0]
+}
+
+// Returns what the pending order of the asset type does when it triggers.
+func orderSide(position_type string) string {
+	parts := strings.SplitN(position_type, "_", 2)
+	return parts[len(parts)-1]
+}
+
+// Returns the type of position the order opens or closes.
+func orderPosition(position_type string) string {
+	switch orderSide(position_type) {
+	case "short", "cover":
+		return "short"
+	}
+
+	return "long"
+}
+
+// Returns whether the pending order of the asset type reserves the shares it closes;
+// limit orders (and stop-limit orders, which become limit orders) and market-on-open
+// orders to sell or cover.
+func reservesShares(position_type string) bool {
+	switch orderKind(position_type) {
+	case "limit", "stoplimit", "open":
+	default:
+		return false
+	}
+
+	switch orderSide(position_type) {
+	case "sell", "cover":
+		return true
+	}
+
+	return false
+}
+
+// Returns whether the pending order of the asset type holds funds to cover it while
+// it waits to trigger.
+func holdsFunds(position_type string) bool {
+	if !isOrder(position_type) {
+		return false
+	}
+
+	switch orderSide(position_type) {
+	case "buy", "short", "cover":
+		return true
+	}
+
+	return false
+}
+
+// Returns the name of the kind of the pending order, for messages to users.
+func orderKindName(position_type string) string {
+	switch orderKind(position_type) {
+	case "open":
+		return "market-on-open"
+	case "stoplimit":
+		return "stop-limit"
+	case "trail":
+		return "trailing stop"
+	}
+
+	return orderKind(position_type)
+}
+
+// Returns a description of the pending order, for messages to users.
+func orderDescription(position_type string) string {
+	return "a " + orderKindName(position_type) + " order"
+}
+
+// Returns the ledger events for placing and filling the pending order.
+func placeEvent(position_type string) string {
+	if orderKind(position_type) == "limit" {
+		return LedgerLimitPlace
+	}
+
+	return LedgerOrderPlace
+}
+
+func fillEvent(position_type string) string {
+	if orderKind(position_type) == "limit" {
+		return LedgerLimitFill
+	}
+
+	return LedgerOrderFill
+}
+
+// Returns the status an order is left in by the ledger event that finished it.
+func finishedStatus(event string) string {
+	switch event {
+	case LedgerLimitFill, LedgerOrderFill:
+		return OrderFilled
+	case LedgerExpire:
+		return OrderExpired
+	}
+
+	return OrderCancelled
+}
+
+// Returns a short ID for an order or lot recorded before they had IDs of their own,
+// derived from what is known about it so it stays the same every time it is loaded.
+func legacyID(parts ...interface{}) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(fmt.Sprint(parts...)))
+	return fmt.Sprintf("%04x", hash.Sum32()&0xffff)
+}
+
+// Returns the ID for an order placed before orders had IDs of their own. It's made up
+// from what both the stored record and the ledger know about the order, so it is the
+// same whichever one the order is loaded from; identical orders are told apart by the
+// order they were placed in.
+func (u *User) legacyOrderID(orderType string, symbol string, quantity float64, target float64) string {
+	for n := 0; ; n++ {
+		if id := legacyID(n, orderType, symbol, quantity, target); !u.orderIDTaken(id) {
+			return id
+		}
+	}
+}
+
+// Returns a new short order ID, unique amongst the user's orders and the brackets their
+// exit legs belong to; those outlive the order that placed them.
+func (u *User) newOrderID() string {
+	for {
+		id := make([]byte, 2)
+		rand.Read(id)
+		if candidate := hex.EncodeToString(id); !u.orderIDTaken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// Returns whether the ID is used by any of the user's orders, or the brackets they
+// belong to.
+func (u *User) orderIDTaken(id string) bool {
+	for i := range u.Orders {
+		if strings.EqualFold(u.Orders[i].ID, id) || strings.EqualFold(u.Orders[i].Group, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the user's order with the specified ID, or nil if there is none.
+func (u *User) findOrder(id string) *Order {
+	for i := range u.Orders {
+		if strings.EqualFold(u.Orders[i].ID, id) {
+			return u.Orders[i]
+		}
+	}
+
+	return nil
+}
+
+// Returns the user's order with the specified ID if it is still waiting to be filled.
+func (u *User) activeOrder(id string) *Order {
+	if order := u.findOrder(id); order != nil && order.Active() {
+		return order
+	}
+
+	return nil
+}
+
+// Returns the user's orders that are still waiting to be filled.
+func (u *User) ActiveOrders() (orders []*Order) {
+	for i := range u.Orders {
+		if u.Orders[i].Active() {
+			orders = append(orders, u.Orders[i])
+		}
+	}
+
+	return orders
+}
+
+// Place the order, holding funds to cover it if it needs them, and record it in the
+// ledger. Orders need their prices to be above zero; ErrInvalidPrice is returned if
+// they aren't.
+func (u *User) placeOrder(order *Order, session string) error {
+	if order.Target <= 0 || order.HoldPrice() <= 0 {
+		return ErrInvalidPrice
+	}
+
+	cost := order.HoldPrice() * order.Quantity
+
+	if order.HoldsFunds() && cost > u.available() {
+		return ErrInsufficientFunds
+	}
+
+	if order.ReservesShares() {
+		if err := u.reserve(order, order.Quantity); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	order.ID = u.newOrderID()
+	order.Owner = u.UserID
+	order.Status = OrderOpen
+	order.Created = now
+	order.Updated = now
+
+	entry := &LedgerEntry{
+		Event:    placeEvent(order.Type),
+		OrderID:  order.ID,
+		Type:     order.Type,
+		Symbol:   order.Symbol,
+		Quantity: order.Quantity,
+		Price:    order.Target,
+		Basis:    order.Target,
+		Limit:    order.Limit,
+		Session:  session,
+
+		Trail:        order.Trail,
+		TrailPercent: order.TrailPercent,
+		TimeInForce:  order.TimeInForce,
+		Expires:      order.Expires,
+		TakeProfit:   order.TakeProfit,
+		StopLoss:     order.StopLoss,
+		Group:        order.Group,
+		Lots:         append([]LotShares{}, order.Lots...),
+	}
+	if order.HoldsFunds() {
+		entry.Amount = -cost
+		entry.Held = cost
+	}
+
+	u.Orders = append(u.Orders, order)
+	u.Funds = u.Funds + entry.Amount
+	u.HeldFunds = u.HeldFunds + entry.Held
+	u.record(entry)
+
+	return nil
+}
+
+// Finish the order under the ledger event; filled, cancelled or expired. Funds held for
+// the shares still to be filled are refunded, and shares reserved for them released.
+func (u *User) finishOrder(order *Order, event string, price float64, session string) {
+	remaining := order.Remaining()
+	u.release(order, order.Reserved())
+	held := order.HoldPrice() * remaining
+
+	entry := &LedgerEntry{
+		Event:    event,
+		OrderID:  order.ID,
+		Type:     order.Type,
+		Symbol:   order.Symbol,
+		Quantity: remaining,
+		Price:    price,
+		Basis:    order.Target,
+		Session:  session,
+	}
+	if order.HoldsFunds() {
+		entry.Amount = held
+		entry.Held = -held
+	}
+
+	order.Status = finishedStatus(event)
+	if order.Status == OrderFilled {
+		order.FillPrice = (order.FillPrice*order.Filled + price*remaining) / order.Quantity
+		order.Filled = order.Quantity
+	}
+	order.Updated = time.Now()
+
+	u.Funds = u.Funds + entry.Amount
+	u.HeldFunds = u.HeldFunds + entry.Held
+	u.record(entry)
+	u.pruneOrders()
+}
+
+// Fill part of the order, refunding the funds held for the shares filled; the rest of
+// the order keeps working.
+func (u *User) fillOrder(order *Order, quantity float64, price float64, session string) {
+	held := order.HoldPrice() * quantity
+
+	entry := &LedgerEntry{
+		Event:    LedgerOrderPartialFill,
+		OrderID:  order.ID,
+		Type:     order.Type,
+		Symbol:   order.Symbol,
+		Quantity: quantity,
+		Price:    price,
+		Basis:    order.Target,
+		Session:  session,
+	}
+	if order.HoldsFunds() {
+		entry.Amount = held
+		entry.Held = -held
+	}
+
+	order.FillPrice = (order.FillPrice*order.Filled + price*quantity) / (order.Filled + quantity)
+	order.Filled = roundShares(order.Filled + quantity)
+	order.Status = OrderPartiallyFilled
+	order.Updated = time.Now()
+
+	u.Funds = u.Funds + entry.Amount
+	u.HeldFunds = u.HeldFunds + entry.Held
+	u.record(entry)
+}
+
+// Drop all but the most recent of the user's finished orders.
+func (u *User) pruneOrders() {
+	var finished int
+	for i := range u.Orders {
+		if !u.Orders[i].Active() {
+			finished = finished + 1
+		}
+	}
+
+	var orders []*Order
+	for i := range u.Orders {
+		order := u.Orders[i]
+		if !order.Active() && finished > ORDER_HISTORY_SIZE {
+			finished = finished - 1
+			continue
+		}
+		orders = append(orders, order)
+	}
+	u.Orders = orders
+}
+
+// Cancel the user's order with the specified ID, recording it under the ledger event.
+func (u *User) cancelOrder(id string, event string, session string) (*Order, error) {
+	order := u.activeOrder(id)
+	if order == nil {
+		return nil, ErrNoMatchingOrder
+	}
+
+	u.finishOrder(order, event, order.Target, session)
+	return order, nil
+}
+
+// Change the quantity and target price of the order; zero leaves either as it is. Held
+// funds are adjusted to cover the order on its new terms.
+func (u *User) amendOrder(id string, quantity float64, target float64, session string) (*Order, error) {
+	order := u.activeOrder(id)
+	if order == nil {
+		return nil, ErrNoMatchingOrder
+	}
+
+	if target != 0 {
+		switch orderKind(order.Type) {
+		case "limit", "stop", "stoplimit":
+		default:
+			return nil, ErrInvalidAmendment
+		}
+	}
+	if quantity != 0 && quantity <= order.Filled {
+		return nil, ErrInvalidAmendment
+	}
+
+	held := order.HoldPrice() * order.Remaining()
+
+	amended := *order
+	if quantity != 0 {
+		amended.Quantity = quantity
+	}
+	if target != 0 {
+		amended.Target = target
+		if !u.bracketInOrder(&amended) {
+			return nil, ErrBracketPrices
+		}
+	}
+
+	var difference float64
+	if order.HoldsFunds() {
+		difference = amended.HoldPrice()*amended.Remaining() - held
+	}
+	if difference > u.available() {
+		return nil, ErrInsufficientFunds
+	}
+
+	if order.ReservesShares() && amended.Remaining() > order.Reserved()+u.unreserved(orderPosition(order.Type), order.Symbol) {
+		return nil, ErrInsufficientShares
+	}
+
+	amended.Updated = time.Now()
+	*order = amended
+	if order.ReservesShares() {
+		u.release(order, order.Reserved())
+		u.reserve(order, order.Remaining())
+	}
+
+	u.Funds = u.Funds - difference
+	u.HeldFunds = u.HeldFunds + difference
+	u.record(&LedgerEntry{
+		Event:    LedgerOrderAmend,
+		OrderID:  order.ID,
+		Type:     order.Type,
+		Symbol:   order.Symbol,
+		Quantity: order.Quantity,
+		Price:    order.Target,
+		Basis:    order.Target,
+		Limit:    order.Limit,
+		Lots:     append([]LotShares{}, order.Lots...),
+		Amount:   -difference,
+		Held:     difference,
+		Session:  session,
+	})
+
+	return order, nil
+}
+
+// Returns whether the prices of the bracket the order belongs to, with the order on its
+// amended terms, are still in order; the take-profit above the entry price and the entry
+// above the stop-loss when buying, and the other way around when shorting. Once the
+// entry has been filled, only the legs are left to keep apart.
+func (u *User) bracketInOrder(amended *Order) bool {
+	group := amended.Group
+	if group == "" {
+		if amended.TakeProfit == 0 {
+			return true
+		}
+		group = amended.ID
+	}
+
+	var entry, take, stop float64
+	short := false
+	if order := u.activeOrder(group); order != nil {
+		if order.ID == amended.ID {
+			order = amended
+		}
+		entry, take, stop = order.Target, order.TakeProfit, order.StopLoss
+		short = orderSide(order.Type) == "short"
+	}
+
+	orders := u.ActiveOrders()
+	for i := range orders {
+		leg := orders[i]
+		if leg.Group != group {
+			continue
+		}
+		if leg.ID == amended.ID {
+			leg = amended
+		}
+
+		switch orderKind(leg.Type) {
+		case "limit":
+			take = leg.Target
+		case "stop":
+			stop = leg.Target
+		}
+		short = orderSide(leg.Type) == "cover"
+	}
+
+	prices := []float64{stop, entry, take}
+	if short {
+		prices = []float64{take, entry, stop}
+	}
+
+	var last float64
+	for i := range prices {
+		if prices[i] == 0 {
+			continue
+		}
+		if prices[i] <= last {
+			return false
+		}
+		last = prices[i]
+	}
+
+	return true
+}
+
+// Returns the number of shares the user holds in positions of the type and symbol that
+// aren't reserved for any of their orders.
+func (u *User) unreserved(position_type string, symbol string) (quantity float64) {
+	for i := range u.Portfolio {
+		if u.Portfolio[i].Type == position_type && u.Portfolio[i].Symbol == symbol {
+			quantity = roundShares(quantity + u.Portfolio[i].Available())
+		}
+	}
+
+	return quantity
+}
+
+// Reserve quantity shares of the position the order closes, from its lots with shares
+// to spare in the order the user's lot relief method closes them.
+func (u *User) reserve(order *Order, quantity float64) error {
+	position_type := orderPosition(order.Type)
+	if u.unreserved(position_type, order.Symbol) < quantity {
+		return ErrInsufficientShares
+	}
+
+	lots := u.positionLots(position_type, order.Symbol)
+	for i := range lots {
+		asset := lots[i]
+		if quantity == 0 {
+			break
+		}
+		if asset.Available() == 0 {
+			continue
+		}
+
+		reserved := quantity
+		if asset.Available() < reserved {
+			reserved = asset.Available()
+		}
+		asset.Reserved = roundShares(asset.Reserved + reserved)
+		quantity = roundShares(quantity - reserved)
+
+		order.Lots = append(order.Lots, LotShares{ID: asset.ID, Basis: asset.CostBasis, Quantity: reserved})
+	}
+
+	return nil
+}
+
+// Reserve the very shares of the lots for the order, as recorded in the ledger. Entries
+// recorded without lots reserve shares as the order would be placed now.
+func (u *User) reserveLots(order *Order, lots []LotShares, quantity float64) {
+	if len(lots) == 0 {
+		u.reserve(order, quantity)
+		return
+	}
+
+	for i := range lots {
+		if asset := u.findLot(lots[i].ID); asset != nil {
+			asset.Reserved = roundShares(asset.Reserved + lots[i].Quantity)
+			order.Lots = append(order.Lots, lots[i])
+		}
+	}
+}
+
+// Release up to quantity of the shares reserved for the order, first reserved first.
+// Returns the lots released, so a fill can close the very shares it had reserved.
+func (u *User) release(order *Order, quantity float64) (released []LotShares) {
+	position_type := orderPosition(order.Type)
+
+	var lots []LotShares
+	for i := range order.Lots {
+		lot := order.Lots[i]
+
+		freed := quantity
+		if lot.Quantity < freed {
+			freed = lot.Quantity
+		}
+		quantity = roundShares(quantity - freed)
+		lot.Quantity = roundShares(lot.Quantity - freed)
+
+		for j, remaining := 0, freed; j < len(u.Portfolio) && remaining > 0; j++ {
+			asset := u.Portfolio[j]
+			if asset.Type != position_type || asset.Symbol != order.Symbol || asset.Reserved == 0 {
+				continue
+			}
+			if (lot.ID != "" && asset.ID == lot.ID) || (lot.ID == "" && asset.CostBasis == lot.Basis) {
+				unreserved := remaining
+				if asset.Reserved < unreserved {
+					unreserved = asset.Reserved
+				}
+				asset.Reserved = roundShares(asset.Reserved - unreserved)
+				remaining = roundShares(remaining - unreserved)
+			}
+		}
+
+		if freed > 0 {
+			released = append(released, LotShares{ID: lot.ID, Basis: lot.Basis, Quantity: freed})
+		}
+		if lot.Quantity > 0 {
+			lots = append(lots, lot)
+		}
+	}
+	order.Lots = lots
+
+	return released
+}
+
+// Close quantity shares of the position, starting with the lots released for the order
+// and taking any shares beyond them from the lots that are unreserved.
+func (u *User) closeLots(event string, position_type string, symbol string, quantity float64, lots []LotShares, price float64, session string) (closed []LotShares, funds float64, gains float64, err error) {
+	lots = append(lots, LotShares{Quantity: quantity})
+	for i := range lots {
+		closing := roundShares(quantity - sharesIn(closed))
+		if lots[i].Quantity < closing {
+			closing = lots[i].Quantity
+		}
+		if closing == 0 {
+			continue
+		}
+
+		basis := lots[i].Basis
+		if lots[i].ID != "" {
+			basis = 0
+		}
+
+		lot_closed, lot_funds, lot_gains, err := u.close(event, position_type, symbol, closing, lots[i].ID, basis, price, session)
+		if err != nil && err != ErrNoMatchingPosition && err != ErrInsufficientShares {
+			return closed, funds, gains, err
+		}
+		closed = append(closed, lot_closed...)
+		funds = funds + lot_funds
+		gains = gains + lot_gains
+	}
+
+	if len(closed) == 0 {
+		return nil, 0, 0, ErrNoMatchingPosition
+	}
+
+	return closed, funds, gains, nil
+}
+
+// Place the take-profit and stop-loss legs of a filled bracket order, for the quantity
+// that was filled. Legs already placed for earlier partial fills of the bracket are
+// resized instead, so it keeps a single pair of legs.
+func (u *User) placeBracket(bracket *Order, quantity float64, session string) (placed []Order, amended []Order, err error) {
+	orders := u.ActiveOrders()
+	for i := range orders {
+		if orders[i].Group == bracket.ID {
+			leg, err := u.amendOrder(orders[i].ID, orders[i].Quantity+quantity, 0, session)
+			if err != nil {
+				return nil, nil, err
+			}
+			amended = append(amended, *leg)
+		}
+	}
+	if len(amended) > 0 {
+		return nil, amended, nil
+	}
+
+	exit := "sell"
+	if orderSide(bracket.Type) == "short" {
+		exit = "cover"
+	}
+
+	legs := []*Order{
+		{Type: "limit_" + exit, Symbol: bracket.Symbol, Quantity: quantity, Target: bracket.TakeProfit, Group: bracket.ID},
+		{Type: "stop_" + exit, Symbol: bracket.Symbol, Quantity: quantity, Target: bracket.StopLoss, Group: bracket.ID},
+	}
+
+	for i := range legs {
+		if err := u.placeOrder(legs[i], session); err != nil {
+			return nil, nil, err
+		}
+		placed = append(placed, *legs[i])
+	}
+
+	return placed, nil, nil
+}
+
+// Cancel the active orders of the group, other than the one with the specified ID.
+func (u *User) cancelGroup(group string, except string, session string) (cancelled []Order) {
+	orders := u.ActiveOrders()
+	for i := range orders {
+		if orders[i].Group == group && orders[i].ID != except {
+			order, _ := u.cancelOrder(orders[i].ID, LedgerCancel, session)
+			cancelled = append(cancelled, *order)
+		}
+	}
+
+	return cancelled
+}
+
+// Shrink the active orders of the group, other than the one with the specified ID, by
+// the quantity partially filled on it; those left with nothing to fill are cancelled.
+func (u *User) reduceGroup(group string, except string, quantity float64, session string) (cancelled []Order, amended []Order) {
+	orders := u.ActiveOrders()
+	for i := range orders {
+		if orders[i].Group != group || orders[i].ID == except {
+			continue
+		}
+
+		if orders[i].Quantity-quantity <= orders[i].Filled {
+			order, _ := u.cancelOrder(orders[i].ID, LedgerCancel, session)
+			cancelled = append(cancelled, *order)
+		} else if order, err := u.amendOrder(orders[i].ID, orders[i].Quantity-quantity, 0, session); err == nil {
+			amended = append(amended, *order)
+		}
+	}
+
+	return cancelled, amended
+}
+
+// Turn a triggered stop-limit order into a limit order at its limit price. The funds
+// held for it are already held at the limit price, so carry straight over.
+func (u *User) triggerOrder(order *Order, price float64, session string) {
+	order.Type = "limit_" + orderSide(order.Type)
+	order.Target = order.Limit
+	order.Limit = 0
+	order.Updated = time.Now()
+
+	u.record(&LedgerEntry{
+		Event:    LedgerOrderTrigger,
+		OrderID:  order.ID,
+		Type:     order.Type,
+		Symbol:   order.Symbol,
+		Quantity: order.Remaining(),
+		Price:    price,
+		Basis:    order.Target,
+		Session:  session,
+	})
+}
+
+// Place the order described by the template; orders without a target are placed at
+// the current market price. The order is then watched until it triggers.
+func (u *User) PlaceOrder(template *Order, source *Command) {
+	user := u
+	quotes.GetQuote(template.Symbol, func(quote TradingViewQuote) (shouldDelete bool) {
+		log := user.log(map[string]interface{}{
+			"method":       "PlaceOrder",
+			"type":         template.Type,
+			"symbol":       template.Symbol,
+			"quantity":     template.Quantity,
+			"target_price": template.Target,
+			"limit_price":  template.Limit,
+			"last_price":   quote.LastPrice,
+		})
+
+		if quote.Symbol != template.Symbol {
+			log.Info("Symbol not found.")
+			source.Say("<@%s> I was unable to find that stock; wanna try that again?", user.UserID)
+			return true
+		}
+
+		if template.TimeInForce == TimeInForceIOC && orderKind(template.Type) != "limit" {
+			source.Say("<@%s>, immediate-or-cancel is only available for limit orders.", user.UserID)
+			return true
+		}
+
+		// Trailing stop and market-on-open orders start from the market price; every
+		// other kind of order is placed at its own prices.
+		order := *template
+		order.Symbol = quote.Symbol
+		switch orderKind(order.Type) {
+		case "trail", "open":
+			if quote.IsStale() {
+				log.WithField("quote_age", quote.Age()).Warn("Refusing to place an order on a stale quote.")
+				source.Say("<@%s>, %s", user.UserID, quote.StaleMessage(quote.Symbol))
+				return true
+			}
+			order.Target = quote.Price()
+		default:
+			if order.Target <= 0 {
+				log.Info("Invalid order price.")
+				source.Say("<@%s>, order prices need to be above $0.", user.UserID)
+				return true
+			}
+		}
+		if order.Trail != 0 {
+			order.HighWater = order.Target
+		}
+
+		var available float64
+		var shares float64
+		err := user.Update(func(user *User) error {
+			available = user.available()
+			shares = user.unreserved(orderPosition(order.Type), order.Symbol)
+			placed := order
+			if err := user.placeOrder(&placed, quote.CurrentSession); err != nil {
+				return err
+			}
+			order = placed
+			return nil
+		})
+
+		if err == ErrInsufficientFunds {
+			log.WithFields(map[string]interface{}{
+				"cost": order.HoldPrice() * order.Quantity,
+			}).Info("Insufficient funds.")
+			source.Say("<@%s>, you don't have enough funds to cover this trade. You have $%.2f available, and at most could do %s shares.", user.UserID, available, formatShares(floorShares(available/order.HoldPrice())))
+			return true
+		} else if err == ErrInvalidPrice {
+			log.Info("Invalid order price.")
+			source.Say("<@%s>, order prices need to be above $0.", user.UserID)
+			return true
+		} else if err == ErrInsufficientShares {
+			log.WithField("unreserved", shares).Info("Insufficient unreserved shares.")
+			source.Say("<@%s>, you don't hold enough shares of %s that aren't already reserved for other orders; you could do at most %s shares.", user.UserID, order.Symbol, formatShares(shares))
+			return true
+		} else if err != nil {
+			log.WithError(err).Error("Unable to save order.")
+			source.Say("<@%s>, something went wrong placing that order; nothing was changed. Wanna try that again?", user.UserID)
+			return true
+		}
+
+		log.WithField("order_id", order.ID).Infof("Created a %s order.", strings.ReplaceAll(order.Type, "_", " "))
+		action := fmt.Sprintf("created %s `%s` to %s", orderDescription(order.Type), order.ID, orderSide(order.Type))
+
+		switch {
+		case order.TakeProfit != 0:
+			source.Say("<@%s> %s %s shares of %s at $%.2f, totalling $%.2f, taking profit at $%.2f or stopping losses at $%.2f once filled. They have $%.2f funds remaining.", user.UserID, action, formatShares(order.Quantity), order.Symbol, order.Target, order.Target*order.Quantity, order.TakeProfit, order.StopLoss, user.Funds)
+		case order.Trail != 0:
+			source.Say("<@%s> %s %s shares of %s at $%.2f trailing by %s, with a stop currently at $%.2f. They have $%.2f funds remaining.", user.UserID, action, formatShares(order.Quantity), order.Symbol, order.Target, order.TrailDescription(), order.TrailStop(), user.Funds)
+		case order.Limit != 0:
+			source.Say("<@%s> %s %s shares of %s at $%.2f with a limit of $%.2f, totalling $%.2f. They have $%.2f funds remaining.", user.UserID, action, formatShares(order.Quantity), order.Symbol, order.Target, order.Limit, order.Limit*order.Quantity, user.Funds)
+		default:
+			source.Say("<@%s> %s %s shares of %s at $%.2f, totalling $%.2f. They have $%.2f funds remaining.", user.UserID, action, formatShares(order.Quantity), order.Symbol, order.Target, order.Target*order.Quantity, user.Funds)
+		}
+
+		user.WatchOrder(&order, source)
+		return true
+	})
+}
+
+// Cancel the user's order with the specified ID, refunding any funds held for it.
+func (u *User) CancelOrder(id string, source *Command) {
+	var order Order
+	err := u.Update(func(user *User) error {
+		cancelled, err := user.cancelOrder(id, LedgerCancel, calendar.Session(time.Now()))
+		if err != nil {
+			return err
+		}
+		order = *cancelled
+		return nil
+	})
+
+	if err == ErrNoMatchingOrder {
+		source.Say("<@%s>, I was unable to find an open order `%s`; check `!orders` for the IDs of your orders.", u.UserID, id)
+		return
+	} else if err != nil {
+		u.log(map[string]interface{}{
+			"method":   "CancelOrder",
+			"order_id": id,
+		}).WithError(err).Error("Unable to cancel order.")
+		source.Say("<@%s>, something went wrong cancelling that order; nothing was changed. Wanna try that again?", u.UserID)
+		return
+	}
+
+	source.Say("<@%s>, your %s has been cancelled. They have $%.2f funds available.", u.UserID, order.Description(), u.Funds)
+}
+
+// Amend the quantity and target price of the user's order with the specified ID, then
+// watch it on its new terms.
+func (u *User) AmendOrder(id string, quantity float64, target float64, source *Command) {
+	var order Order
+	var available float64
+	err := u.Update(func(user *User) error {
+		available = user.available()
+		amended, err := user.amendOrder(id, quantity, target, calendar.Session(time.Now()))
+		if err != nil {
+			return err
+		}
+		order = *amended
+		return nil
+	})
+
+	switch err {
+	case nil:
+	case ErrNoMatchingOrder:
+		source.Say("<@%s>, I was unable to find an open order `%s`; check `!orders` for the IDs of your orders.", u.UserID, id)
+		return
+	case ErrInvalidAmendment:
+		source.Say("<@%s>, that order can't be amended that way; only limit and stop orders have a price to change, and the quantity can't be less than what has already been filled.", u.UserID)
+		return
+	case ErrBracketPrices:
+		source.Say("<@%s>, that would leave your bracket's prices out of order; the take-profit needs to stay above the entry price and the entry above the stop-loss when buying, and the other way around when shorting.", u.UserID)
+		return
+	case ErrInsufficientFunds:
+		source.Say("<@%s>, you don't have enough funds to cover the amended order. You have $%.2f available.", u.UserID, available)
+		return
+	case ErrInsufficientShares:
+		source.Say("<@%s>, you don't hold enough shares that aren't already reserved for other orders to cover the amended order.", u.UserID)
+		return
+	default:
+		u.log(map[string]interface{}{
+			"method":   "AmendOrder",
+			"order_id": id,
+		}).WithError(err).Error("Unable to amend order.")
+		source.Say("<@%s>, something went wrong amending that order; nothing was changed. Wanna try that again?", u.UserID)
+		return
+	}
+
+	source.Say("<@%s> amended their %s at $%.2f. They have $%.2f being held for orders, and $%.2f available.", u.UserID, order.Description(), order.Target, u.HeldFunds, u.Funds)
+	u.WatchOrder(&order, source)
+}
+
+// Returns whether the price meets the trigger of a limit, stop or stop-limit order.
+// Limit orders trigger at their target price or better, stops once the price moves
+// against the player to their stop price.
+func orderTriggered(order *Order, price float64) bool {
+	switch orderKind(order.Type) {
+	case "limit":
+		switch orderSide(order.Type) {
+		case "buy", "cover":
+			return price <= order.Target
+		case "sell", "short":
+			return price >= order.Target
+		}
+	case "stop", "stoplimit":
+		switch orderSide(order.Type) {
+		case "sell":
+			return price <= order.Target
+		case "buy", "cover":
+			return price >= order.Target
+		}
+	}
+
+	return false
+}
+
+// Returns whether the order is still waiting to be filled in storage on the same terms,
+// so watches for orders that have since been filled, cancelled, expired or amended can
+// be deleted.
+func (u *User) stillActive(order *Order) bool {
+	current, err := Storage.Get(u.UserID)
+	if err != nil {
+		return false
+	}
+
+	stored := current.activeOrder(order.ID)
+	return stored != nil && stored.sameTerms(order)
+}
+
+// Start watching the pending order, executing it once its trigger is met.
+func (u *User) WatchOrder(order *Order, source *Command) {
+	if order.TimeInForce == TimeInForceIOC {
+		u.FillOrExpireOrder(order, source)
+		return
+	}
+
+	switch orderKind(order.Type) {
+	case "limit":
+		u.WatchLimitOrder(order, source)
+	case "open":
+		u.WatchOpenOrder(order, source)
+	case "stop", "stoplimit":
+		u.WatchStopOrder(order, source)
+	case "trail":
+		u.WatchTrailOrder(order, source)
+	}
+}
+
+func (u *User) WatchLimitOrder(order *Order, source *Command) {
+	user := u
+	user.log(map[string]interface{}{
+		"method":       "WatchLimitOrder:OnUpdate",
+		"order_id":     order.ID,
+		"type":         order.Type,
+		"symbol":       order.Symbol,
+		"quantity":     order.Quantity,
+		"target_price": order.Target,
+	}).Info("Creating a new watch limit order job.")
+
+	quotes.OnUpdate(order.Symbol, func(quote TradingViewQuote) (shouldDelete bool) {
+		log := user.log(map[string]interface{}{
+			"method":       "WatchLimitOrder:OnUpdate",
+			"order_id":     order.ID,
+			"type":         order.Type,
+			"symbol":       order.Symbol,
+			"quantity":     order.Quantity,
+			"target_price": order.Target,
+			"last_price":   quote.LastPrice,
+		})
+
+		if !user.stillActive(order) {
+			log.Info("Limit order no longer exists; deleting watch.")
+			return true
+		}
+
+		if quote.IsStale() {
+			log.WithField("quote_age", quote.Age()).Warn("Not filling limit order on a stale quote.")
+			return false
+		}
+
+		if !calendar.CanTrade(time.Now()) {
+			return false
+		}
+
+		cost_basis := quote.Price()
+
+		if !orderTriggered(order, cost_basis) {
+			log.Info("No criteria met to action on watch order.")
+			return false
+		}
+
+		return user.executeOrder(order, quote, source)
+	})
+}
+
+// Watch a market-on-open order, executing it at the first fresh quote of the regular
+// session.
+func (u *User) WatchOpenOrder(order *Order, source *Command) {
+	user := u
+	user.log(map[string]interface{}{
+		"method":   "WatchOpenOrder:OnUpdate",
+		"order_id": order.ID,
+		"type":     order.Type,
+		"symbol":   order.Symbol,
+		"quantity": order.Quantity,
+	}).Info("Creating a new watch market-on-open order job.")
+
+	quotes.OnUpdate(order.Symbol, func(quote TradingViewQuote) (shouldDelete bool) {
+		log := user.log(map[string]interface{}{
+			"method":     "WatchOpenOrder:OnUpdate",
+			"order_id":   order.ID,
+			"type":       order.Type,
+			"symbol":     order.Symbol,
+			"quantity":   order.Quantity,
+			"session":    quote.CurrentSession,
+			"last_price": quote.LastPrice,
+		})
+
+		if quote.CurrentSession != SessionMarket || calendar.Session(time.Now()) != SessionMarket || quote.IsStale() {
+			return false
+		}
+
+		if !user.stillActive(order) {
+			log.Info("Market-on-open order no longer exists; deleting watch.")
+			return true
+		}
+
+		log.Info("Market has opened; executing market-on-open order.")
+		return user.executeOrder(order, quote, source)
+	})
+}
+
+// Watch a stop or stop-limit order, which triggers once the price moves against the
+// player; at or below the stop price for sells, at or above it for buys and covers.
+// Stop orders then execute at the market, while stop-limit orders become a limit order
+// at their limit price.
+func (u *User) WatchStopOrder(order *Order, source *Command) {
+	user := u
+	user.log(map[string]interface{}{
+		"method":      "WatchStopOrder:OnUpdate",
+		"order_id":    order.ID,
+		"type":        order.Type,
+		"symbol":      order.Symbol,
+		"quantity":    order.Quantity,
+		"stop_price":  order.Target,
+		"limit_price": order.Limit,
+	}).Info("Creating a new watch stop order job.")
+
+	quotes.OnUpdate(order.Symbol, func(quote TradingViewQuote) (shouldDelete bool) {
+		log := user.log(map[string]interface{}{
+			"method":      "WatchStopOrder:OnUpdate",
+			"order_id":    order.ID,
+			"type":        order.Type,
+			"symbol":      order.Symbol,
+			"quantity":    order.Quantity,
+			"stop_price":  order.Target,
+			"limit_price": order.Limit,
+			"last_price":  quote.LastPrice,
+		})
+
+		if !user.stillActive(order) {
+			log.Info("Stop order no longer exists; deleting watch.")
+			return true
+		}
+
+		if quote.IsStale() || !calendar.CanTrade(time.Now()) {
+			return false
+		}
+
+		cost_basis := quote.Price()
+
+		// Once a stop has started filling, it keeps filling at the market whatever the
+		// price does.
+		if order.Filled == 0 && !orderTriggered(order, cost_basis) {
+			return false
+		}
+
+		if orderKind(order.Type) == "stop" {
+			log.Info("Stop price has been met; executing at the market.")
+			return user.executeOrder(order, quote, source)
+		}
+
+		var limit Order
+		err := user.Update(func(user *User) error {
+			current := user.activeOrder(order.ID)
+			if current == nil {
+				return ErrNoMatchingOrder
+			}
+			user.triggerOrder(current, cost_basis, quote.CurrentSession)
+			limit = *current
+			return nil
+		})
+		if err == ErrNoMatchingOrder {
+			log.Info("Stop-limit order no longer exists; deleting watch.")
+			return true
+		} else if err != nil {
+			log.WithError(err).Error("Unable to convert stop-limit order; will retry on the next update.")
+			return false
+		}
+
+		log.Info("Stop price has been met; converted to a limit order.")
+		source.Say("<@%s>'s stop-limit order `%s` to %s %s of %s has been triggered at $%.2f; it is now a limit order at $%.2f.", user.UserID, limit.ID, orderSide(limit.Type), formatShares(limit.Quantity), limit.Symbol, cost_basis, limit.Target)
+		user.WatchLimitOrder(&limit, source)
+		return true
+	})
+}
+
+// Watch a trailing stop sell order, raising its high-water mark as the price climbs and
+// executing it at the market once the price falls back from the high by the trail
+// amount. New highs are persisted on the order, so the trail survives restarts.
+func (u *User) WatchTrailOrder(order *Order, source *Command) {
+	user := u
+	user.log(map[string]interface{}{
+		"method":     "WatchTrailOrder:OnUpdate",
+		"order_id":   order.ID,
+		"type":       order.Type,
+		"symbol":     order.Symbol,
+		"quantity":   order.Quantity,
+		"trail":      order.TrailDescription(),
+		"high_water": order.HighWater,
+	}).Info("Creating a new watch trailing stop order job.")
+
+	quotes.OnUpdate(order.Symbol, func(quote TradingViewQuote) (shouldDelete bool) {
+		log := user.log(map[string]interface{}{
+			"method":     "WatchTrailOrder:OnUpdate",
+			"order_id":   order.ID,
+			"type":       order.Type,
+			"symbol":     order.Symbol,
+			"quantity":   order.Quantity,
+			"trail":      order.TrailDescription(),
+			"high_water": order.HighWater,
+			"last_price": quote.LastPrice,
+		})
+
+		if !user.stillActive(order) {
+			log.Info("Trailing stop order no longer exists; deleting watch.")
+			return true
+		}
+
+		if quote.IsStale() {
+			return false
+		}
+
+		cost_basis := quote.Price()
+
+		if cost_basis > order.HighWater {
+			err := user.Update(func(user *User) error {
+				current := user.activeOrder(order.ID)
+				if current == nil {
+					return ErrNoMatchingOrder
+				}
+				if cost_basis > current.HighWater {
+					current.HighWater = cost_basis
+					current.Updated = time.Now()
+				}
+				return nil
+			})
+			if err == ErrNoMatchingOrder {
+				log.Info("Trailing stop order no longer exists; deleting watch.")
+				return true
+			} else if err != nil {
+				log.WithError(err).Error("Unable to record new high-water mark; will retry on the next update.")
+				return false
+			}
+
+			order.HighWater = cost_basis
+			return false
+		}
+
+		if !calendar.CanTrade(time.Now()) || (order.Filled == 0 && cost_basis > order.TrailStop()) {
+			return false
+		}
+
+		log.WithField("stop_price", order.TrailStop()).Info("Trailing stop has been hit; executing at the market.")
+		return user.executeOrder(order, quote, source)
+	})
+}
+
+// Fill the pending order against the quote, as far as the liquidity model allows, and
+// execute it against the market, in a single update so a concurrent cancel or manual
+// trade can't leave us with half a fill. Whatever isn't filled keeps working. Returns
+// whether the order's watch should be deleted.
+func (u *User) executeOrder(order *Order, quote TradingViewQuote, source *Command) (shouldDelete bool) {
+	user := u
+	price := fillPrice(order, quote)
+	session := quote.CurrentSession
+	log := user.log(map[string]interface{}{
+		"method":       "executeOrder",
+		"order_id":     order.ID,
+		"type":         order.Type,
+		"symbol":       order.Symbol,
+		"quantity":     order.Quantity,
+		"filled":       order.Filled,
+		"target_price": order.Target,
+		"price":        price,
+		"volume":       quote.TradedVolume,
+	})
+
+	// Nothing fills on an update without volume traded since the previous one to fill
+	// against; wait for the next.
+	if fillQuantity(order.Remaining(), quote) == 0 {
+		log.Debug("No volume to fill against; waiting for the next update.")
+		return false
+	}
+
+	side := orderSide(order.Type)
+
+	var fill orderFill
+	err := user.Update(func(user *User) (err error) {
+		fill, err = user.fillOrderAt(order.ID, quote, price, session)
+		return err
+	})
+
+	if err == ErrNoMatchingOrder {
+		log.Info("Order no longer exists; deleting watch.")
+		return true
+	} else if err == ErrNoMatchingPosition {
+		log.Info("Underlying position no longer exists; cancelling.")
+		user.Update(func(user *User) (err error) {
+			fill.cancelled = nil
+			if _, err = user.cancelOrder(order.ID, LedgerCancel, session); err == nil && order.Group != "" {
+				fill.cancelled = user.cancelGroup(order.Group, order.ID, session)
+			}
+			return err
+		})
+		source.Say("<@%s>, your %s could not be completed as you no longer hold the shares, and has been cancelled.", user.UserID, order.Description())
+		for i := range fill.cancelled {
+			source.Say("<@%s>, your %s has been cancelled along with it.", user.UserID, fill.cancelled[i].Description())
+		}
+		return true
+	} else if err == ErrInsufficientFunds && orderKind(order.Type) != "limit" {
+		// The market opened higher than the funds held for the order; there's no point
+		// waiting for another open.
+		log.Info("Insufficient funds to fill order; cancelling.")
+		user.Update(func(user *User) (err error) {
+			_, err = user.cancelOrder(order.ID, LedgerCancel, session)
+			return err
+		})
+		source.Say("<@%s>, your %s at $%.2f has been cancelled as you don't have enough funds to cover it.", user.UserID, order.Description(), price)
+		return true
+	} else if err != nil {
+		log.WithError(err).Error("Unable to execute order; will retry on the next update.")
+		return false
+	}
+
+	// Watches compare their copy of the order with the stored one, so keep it current.
+	order.Filled = fill.order.Filled
+	order.Lots = fill.order.Lots
+
+	switch side {
+	case "buy":
+		log.Info("Order has been met; filled order, and created long.")
+		source.Say("<@%s> bought %s shares of %s at $%.2f, totalling $%.2f. They have $%.2f funds remaining.", user.UserID, formatShares(fill.quantity), order.Symbol, price, price*fill.quantity, user.Funds)
+	case "short":
+		log.Info("Order has been met; filled order, and created short.")
+		source.Say("<@%s> shorted %s shares of %s at $%.2f, totalling $%.2f. They have $%.2f funds remaining.", user.UserID, formatShares(fill.quantity), order.Symbol, price, price*fill.quantity, user.Funds)
+	case "sell":
+		log.Info("Order has been met; filled order, and sold long.")
+		source.Say("<@%s> sold %s shares of %s from %s at $%.2f, totalling $%.2f, netting them $%.2f. They have $%.2f funds remaining.", user.UserID, formatShares(sharesIn(fill.closed)), order.Symbol, describeLots(fill.closed), price, fill.funds, fill.gains, user.Funds)
+	case "cover":
+		log.Info("Order has been met; filled order, and covered short.")
+		source.Say("<@%s> covered %s shares of %s from %s at $%.2f, totalling $%.2f, netting them $%.2f. They have $%.2f funds remaining.", user.UserID, formatShares(sharesIn(fill.closed)), order.Symbol, describeLots(fill.closed), price, fill.funds, fill.gains, user.Funds)
+	}
+
+	if fill.order.Active() {
+		source.Say("<@%s>'s %s order `%s` to %s has been partially filled; %s of %s shares have been filled at an average of $%.2f, and the remaining %s are still working.", user.UserID, orderKindName(order.Type), order.ID, side, formatShares(fill.order.Filled), formatShares(fill.order.Quantity), fill.order.FillPrice, formatShares(fill.order.Remaining()))
+	} else {
+		source.Say("<@%s>'s %s order `%s` to %s has been completed.", user.UserID, orderKindName(order.Type), order.ID, side)
+	}
+
+	for i := range fill.cancelled {
+		source.Say("<@%s>'s %s has been cancelled, as the other side of its bracket was filled.", user.UserID, fill.cancelled[i].Description())
+	}
+
+	if len(fill.legs) > 0 {
+		source.Say("<@%s>'s bracket is now in place; %s `%s` to take profit at $%.2f, and %s `%s` to stop losses at $%.2f.", user.UserID, orderDescription(fill.legs[0].Type), fill.legs[0].ID, fill.legs[0].Target, orderDescription(fill.legs[1].Type), fill.legs[1].ID, fill.legs[1].Target)
+		for i := range fill.legs {
+			user.WatchOrder(&fill.legs[i], source)
+		}
+	}
+
+	for i := range fill.amended {
+		log.WithField("leg_id", fill.amended[i].ID).Info("Resized bracket leg to match the position.")
+		user.WatchOrder(&fill.amended[i], source)
+	}
+
+	if side == "buy" || side == "short" {
+		user.WatchMargin(order.Symbol)
+	}
+
+	return !fill.order.Active()
+}
+
+// The outcome of filling an order; the shares filled, the lots closed and what closing
+// them brought in, the order as it stands after, and the legs of its bracket that were
+// placed, cancelled or resized along with it.
+type orderFill struct {
+	quantity  float64
+	closed    []LotShares
+	funds     float64
+	gains     float64
+	order     Order
+	legs      []Order
+	cancelled []Order
+	amended   []Order
+}
+
+// Fill as much of the user's order with the ID as the quote has volume for, at the
+// price, opening or closing the position it trades, and placing or adjusting the legs of
+// its bracket.
+func (u *User) fillOrderAt(id string, quote TradingViewQuote, price float64, session string) (fill orderFill, err error) {
+	current := u.activeOrder(id)
+	if current == nil {
+		return fill, ErrNoMatchingOrder
+	}
+
+	fill.quantity = fillQuantity(current.Remaining(), quote)
+
+	position_type := orderPosition(current.Type)
+	if current.Group != "" {
+		// The position a bracket protects may have been partly closed by hand since;
+		// only close what is left of it.
+		if held := u.unreserved(position_type, current.Symbol); held < fill.quantity {
+			fill.quantity = held
+		}
+		if fill.quantity == 0 {
+			return fill, ErrNoMatchingPosition
+		}
+	}
+
+	lots := u.release(current, fill.quantity)
+	if fill.quantity < current.Remaining() {
+		u.fillOrder(current, fill.quantity, price, session)
+		if current.Group != "" {
+			fill.cancelled, fill.amended = u.reduceGroup(current.Group, current.ID, fill.quantity, session)
+		}
+	} else {
+		u.finishOrder(current, fillEvent(current.Type), price, session)
+		if current.Group != "" {
+			fill.cancelled = u.cancelGroup(current.Group, current.ID, session)
+		}
+	}
+	fill.order = *current
+
+	switch orderSide(current.Type) {
+	case "buy":
+		_, err = u.open("long", current.Symbol, fill.quantity, price, session)
+	case "short":
+		_, err = u.open("short", current.Symbol, fill.quantity, price, session)
+	case "sell":
+		fill.closed, fill.funds, fill.gains, err = u.closeLots(LedgerSell, position_type, current.Symbol, fill.quantity, lots, price, session)
+	case "cover":
+		fill.closed, fill.funds, fill.gains, err = u.closeLots(LedgerCover, position_type, current.Symbol, fill.quantity, lots, price, session)
+	}
+	if err != nil {
+		return fill, err
+	}
+
+	if current.TakeProfit != 0 || current.StopLoss != 0 {
+		fill.legs, fill.amended, err = u.placeBracket(current, fill.quantity, session)
+	}
+	return fill, err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewOrderIDIsUniquePerUser(t *testing.T) {
+	user := &User{UserID: "U1"}
+	seen := map[string]bool{}
+	for i := 0; i < 4096; i++ {
+		id := user.newOrderID()
+		if seen[id] {
+			t.Fatalf("order ID %s handed out twice", id)
+		}
+		seen[id] = true
+		user.Orders = append(user.Orders, &Order{ID: id, Status: OrderFilled})
+	}
+}
+
+func TestOrderIDTakenByBracket(t *testing.T) {
+	// The entry order of the bracket has been pruned, but its exit legs live on.
+	user := &User{
+		UserID: "U1",
+		Orders: []*Order{
+			{ID: "a1b2", Type: "limit_sell", Group: "c3d4", Status: OrderOpen},
+			{ID: "e5f6", Type: "stop_sell", Group: "c3d4", Status: OrderOpen},
+		},
+	}
+
+	tests := []struct {
+		id    string
+		taken bool
+	}{
+		{id: "a1b2", taken: true},
+		{id: "E5F6", taken: true},
+		{id: "c3d4", taken: true},
+		{id: "ffff", taken: false},
+	}
+
+	for _, test := range tests {
+		if taken := user.orderIDTaken(test.id); taken != test.taken {
+			t.Errorf("%s: taken = %v, want %v", test.id, taken, test.taken)
+		}
+	}
+}
+
+func TestAmendKeepsBracketPricesInOrder(t *testing.T) {
+	newUser := func() *User {
+		return &User{
+			UserID:    "U1",
+			Funds:     10000,
+			HeldFunds: 1000,
+			Orders: []*Order{
+				{ID: "e1", Type: "limit_buy", Symbol: "AAPL", Quantity: 10, Target: 100, TakeProfit: 110, StopLoss: 90, Status: OrderOpen},
+				{ID: "t2", Type: "limit_sell", Symbol: "MSFT", Quantity: 5, Target: 310, Group: "e2", Status: OrderOpen},
+				{ID: "s2", Type: "stop_sell", Symbol: "MSFT", Quantity: 5, Target: 290, Group: "e2", Status: OrderOpen},
+				{ID: "t3", Type: "limit_cover", Symbol: "TSLA", Quantity: 5, Target: 180, Group: "e3", Status: OrderOpen},
+				{ID: "s3", Type: "stop_cover", Symbol: "TSLA", Quantity: 5, Target: 220, Group: "e3", Status: OrderOpen},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		target float64
+		err    error
+	}{
+		{name: "entry within its legs", id: "e1", target: 105},
+		{name: "entry above its take-profit", id: "e1", target: 115, err: ErrBracketPrices},
+		{name: "entry below its stop-loss", id: "e1", target: 85, err: ErrBracketPrices},
+		{name: "take-profit above the stop", id: "t2", target: 295},
+		{name: "take-profit below the stop", id: "t2", target: 285, err: ErrBracketPrices},
+		{name: "stop above the take-profit", id: "s2", target: 320, err: ErrBracketPrices},
+		{name: "short take-profit below the stop", id: "t3", target: 200},
+		{name: "short take-profit above the stop", id: "t3", target: 225, err: ErrBracketPrices},
+		{name: "short stop below the take-profit", id: "s3", target: 170, err: ErrBracketPrices},
+	}
+
+	for _, test := range tests {
+		user := newUser()
+		if _, err := user.amendOrder(test.id, 0, test.target, SessionMarket); err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestOpenOrderReservesShares(t *testing.T) {
+	user := &User{
+		UserID:    "U1",
+		Funds:     10000,
+		LotMethod: LotsHIFO,
+		Portfolio: []*Asset{
+			{ID: "a1", Type: "long", Symbol: "AAPL", CostBasis: 100, Quantity: 10},
+			{ID: "a2", Type: "long", Symbol: "AAPL", CostBasis: 120, Quantity: 10},
+		},
+	}
+
+	order := &Order{Type: "open_sell", Symbol: "AAPL", Quantity: 15, Target: 110}
+	if err := user.placeOrder(order, SessionClosed); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []LotShares{{ID: "a2", Basis: 120, Quantity: 10}, {ID: "a1", Basis: 100, Quantity: 5}}
+	if len(order.Lots) != len(want) {
+		t.Fatalf("reserved %v, want %v", order.Lots, want)
+	}
+	for i := range want {
+		if order.Lots[i] != want[i] {
+			t.Errorf("reserved %v, want %v", order.Lots, want)
+		}
+	}
+
+	if _, _, _, err := user.close(LedgerSell, "long", "AAPL", 10, "", 0, 110, SessionMarket); err != nil {
+		t.Fatal(err)
+	}
+	if shares := user.unreserved("long", "AAPL"); shares != 0 {
+		t.Errorf("%v unreserved shares left, want 0", shares)
+	}
+	if held := user.findLot("a1").Quantity; held != 5 {
+		t.Errorf("lot a1 has %v shares left, want the 5 reserved for the order", held)
+	}
+}
+
+func TestPlaceOrderRejectsNonPositivePrices(t *testing.T) {
+	tests := []struct {
+		name  string
+		order Order
+		err   error
+	}{
+		{name: "limit buy", order: Order{Type: "limit_buy", Symbol: "AAPL", Quantity: 10, Target: 100}},
+		{name: "negative limit buy", order: Order{Type: "limit_buy", Symbol: "AAPL", Quantity: 10, Target: -100}, err: ErrInvalidPrice},
+		{name: "zero stop buy", order: Order{Type: "stop_buy", Symbol: "AAPL", Quantity: 10}, err: ErrInvalidPrice},
+		{name: "negative stop-limit buy", order: Order{Type: "stoplimit_buy", Symbol: "AAPL", Quantity: 100, Target: 1, Limit: -1000}, err: ErrInvalidPrice},
+		{name: "negative stop on a stop-limit buy", order: Order{Type: "stoplimit_buy", Symbol: "AAPL", Quantity: 10, Target: -1, Limit: 100}, err: ErrInvalidPrice},
+		{name: "negative stop sell", order: Order{Type: "stop_sell", Symbol: "AAPL", Quantity: 10, Target: -5}, err: ErrInvalidPrice},
+	}
+
+	for _, test := range tests {
+		user := &User{
+			UserID:    "U1",
+			Funds:     10000,
+			Portfolio: []*Asset{{ID: "a1", Type: "long", Symbol: "AAPL", CostBasis: 100, Quantity: 10}},
+		}
+
+		order := test.order
+		if err := user.placeOrder(&order, SessionMarket); err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+		if test.err != nil && (user.Funds != 10000 || user.HeldFunds != 0 || len(user.Orders) != 0) {
+			t.Errorf("%s: rejected order left funds at %v with %v held and %d orders", test.name, user.Funds, user.HeldFunds, len(user.Orders))
+		}
+	}
+}
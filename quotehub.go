@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Return a new, empty QuoteHub.
+func NewQuoteHub() *QuoteHub {
+	return &QuoteHub{
+		quotes:      make(map[string]TradingViewQuote),
+		subscribers: make(map[string][]*TradingViewNotifications),
+		mutex:       &sync.RWMutex{},
+	}
+}
+
+// Start tracking the symbol with an empty placeholder quote. Returns false if the
+// symbol was already being tracked.
+func (h *QuoteHub) track(symbol string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.quotes[symbol]; ok {
+		return false
+	}
+
+	h.quotes[symbol] = TradingViewQuote{}
+	return true
+}
+
+// Returns whether the symbol is being tracked.
+func (h *QuoteHub) IsWatching(symbol string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	_, ok := h.quotes[symbol]
+	return ok
+}
+
+// Returns every symbol being tracked.
+func (h *QuoteHub) Symbols() (symbols []string) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for symbol := range h.quotes {
+		symbols = append(symbols, symbol)
+	}
+
+	return symbols
+}
+
+// Retrieve the most recent quote for the specified symbol.
+// If the symbol is already being watched, it will return the latest quote available,
+// and a true value to indicate this request was successful.
+// If the symbol is not watched, it will return an empty TradingViewQuote struct, and
+// a false value to indicate the quote is not being watched. A symbol that is watched
+// but hasn't received its first update returns an empty placeholder with a zero
+// ReceivedAt.
+func (h *QuoteHub) GetCurrent(symbol string) (quote TradingViewQuote, ok bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	quote, ok = h.quotes[symbol]
+	return quote, ok
+}
+
+// Add a subscriber to updates of the symbol.
+func (h *QuoteHub) subscribe(symbol string, callback func(TradingViewQuote) (shouldDelete bool)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.subscribers[symbol] = append(h.subscribers[symbol], &TradingViewNotifications{
+		Symbol: symbol,
+		Action: callback,
+	})
+}
+
+// Store the latest quote for the symbol, stamped with the time it was received and the
+// volume traded since the previous one, and pass it to each of the symbol's subscribers,
+// dropping those that ask to be deleted. Subscribers are called without the lock held,
+// so they are free to look up quotes or subscribe to more updates.
+func (h *QuoteHub) publish(symbol string, quote TradingViewQuote) {
+	quote.ReceivedAt = time.Now()
+
+	h.mutex.Lock()
+	quote.TradedVolume = 0
+	if previous, ok := h.quotes[symbol]; ok && previous.Volume > 0 && quote.Volume > previous.Volume {
+		quote.TradedVolume = quote.Volume - previous.Volume
+	}
+	h.quotes[symbol] = quote
+	subscribers := append([]*TradingViewNotifications{}, h.subscribers[symbol]...)
+	h.mutex.Unlock()
+
+	finished := map[*TradingViewNotifications]bool{}
+	for i := range subscribers {
+		if subscribers[i].Action(quote) {
+			finished[subscribers[i]] = true
+		}
+	}
+
+	if len(finished) == 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var remaining []*TradingViewNotifications
+	for _, subscriber := range h.subscribers[symbol] {
+		if !finished[subscriber] {
+			remaining = append(remaining, subscriber)
+		}
+	}
+
+	if len(remaining) == 0 {
+		delete(h.subscribers, symbol)
+	} else {
+		h.subscribers[symbol] = remaining
+	}
+}
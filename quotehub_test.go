@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Run with -race; quotes are published from the feed's goroutine while commands look
+// them up and subscribe to updates from their own.
+func TestQuoteHubConcurrentAccess(t *testing.T) {
+	tv := NewTradingView()
+	symbols := []string{"AAPL", "MSFT", "BRK.A"}
+
+	publish := func(symbol string, price int) {
+		message := fmt.Sprintf(`{"m":"qsd","p":["qs_test",{"n":"NYSE:%s","s":"ok","v":{"short_name":"%s","lp":%d,"volume":%d}}]}`, symbol, symbol, price, price*100)
+		if err := tv.parseTradingViewEvent(message); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var requested, resolved int64
+	request := func() func(TradingViewQuote) bool {
+		atomic.AddInt64(&requested, 1)
+		var fired int32
+		return func(quote TradingViewQuote) bool {
+			if atomic.CompareAndSwapInt32(&fired, 0, 1) {
+				atomic.AddInt64(&resolved, 1)
+			}
+			tv.GetCurrent(quote.Symbol)
+			return true
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			for price := 1; price <= 200; price++ {
+				publish(symbols[price%len(symbols)], price)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				symbol := symbols[n%len(symbols)]
+				tv.GetQuote(symbol, request())
+				tv.OnUpdate(symbol, func(quote TradingViewQuote) bool {
+					// Subscribing from within a callback mustn't deadlock.
+					tv.OnUpdate(symbol, request())
+					return true
+				})
+				tv.GetCurrent(symbol)
+				tv.IsWatching(symbol)
+				tv.Symbols()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Settle anything subscribed after the last update of its symbol.
+	for round := 0; round < 2; round++ {
+		for _, symbol := range symbols {
+			publish(symbol, 1000)
+		}
+	}
+
+	if requested != resolved {
+		t.Errorf("%d of %d quote requests were answered", resolved, requested)
+	}
+	if watching := tv.Symbols(); len(watching) != len(symbols) {
+		t.Errorf("watching %v, want %v", watching, symbols)
+	}
+	for _, symbol := range symbols {
+		if quote, _ := tv.GetCurrent(symbol); quote.LastPrice != 1000 {
+			t.Errorf("%s: got last price %v, want 1000", symbol, quote.LastPrice)
+		}
+	}
+}
@@ -4,72 +4,196 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"sync"
+	"math/rand"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
 )
 
-var Redis *RedisClient
+var REDIS_UPDATE_RETRIES = 25
 
-func InitRedis(config RedisConfig) {
-	if Redis == nil {
-		opt, err := redis.ParseURL(config.RedisURL)
-		if err != nil {
-			panic(err)
-		}
+// Connect to Redis, blocking until the server responds to a ping.
+func NewRedisClient(config RedisConfig) *RedisClient {
+	opt, err := redis.ParseURL(config.RedisURL)
+	if err != nil {
+		panic(err)
+	}
 
-		r := redis.NewClient(opt)
+	r := redis.NewClient(opt)
 
-		result, err := r.Ping(r.Context()).Result()
+	result, err := r.Ping(r.Context()).Result()
 
-		for err != nil || result != "PONG" {
-			log.WithFields(log.Fields{
-				"url":    config.RedisURL,
-				"err":    err,
-				"result": result,
-			}).Error("Retrying connection to redis.")
+	for err != nil || result != "PONG" {
+		log.WithFields(log.Fields{
+			"url":    config.RedisURL,
+			"err":    err,
+			"result": result,
+		}).Error("Retrying connection to redis.")
 
-			time.Sleep(5 * time.Second)
-			result, err = r.Ping(r.Context()).Result()
-		}
+		time.Sleep(5 * time.Second)
+		result, err = r.Ping(r.Context()).Result()
+	}
 
-		Redis = &RedisClient{
-			client: r,
-			quit:   make(chan struct{}),
-			mutex:  &sync.Mutex{},
-			prefix: config.Prefix,
-		}
+	log.Info("Connected to redis.")
 
-		log.Info("Connected to redis.")
+	return &RedisClient{
+		client: r,
+		quit:   make(chan struct{}),
+		prefix: config.Prefix,
 	}
 }
 
-func (r *RedisClient) Set(userID string, value *User) error {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+func (r *RedisClient) ledgerKey(userID string) string {
+	return r.prefix + ":ledger:" + userID
+}
+
+// Every write to a user record bumps a version key of its own. Updates watch that key
+// rather than the hash all users share, so they only retry on writes to the same user.
+func (r *RedisClient) versionKey(userID string) string {
+	return r.prefix + ":version:" + userID
+}
 
+// Queue writing the user record, along with any ledger entries queued against it, on
+// the pipeline, bumping the version of the record.
+func (r *RedisClient) queueWrite(pipe redis.Pipeliner, userID string, value *User) {
+	ctx := r.client.Context()
 	data, _ := json.Marshal(value)
 
-	if _, err := r.client.HSet(r.client.Context(), r.prefix, userID, data).Result(); err != nil {
+	pipe.HSet(ctx, r.prefix, userID, data)
+	pipe.Incr(ctx, r.versionKey(userID))
+	for i := range value.ledger {
+		entry, _ := json.Marshal(value.ledger[i])
+		pipe.RPush(ctx, r.ledgerKey(userID), entry)
+	}
+}
+
+// Append entries to the user's ledger without touching the user record.
+func (r *RedisClient) AppendLedger(userID string, entries ...*LedgerEntry) error {
+	var values []interface{}
+	for i := range entries {
+		entry, _ := json.Marshal(entries[i])
+		values = append(values, entry)
+	}
+
+	return r.client.RPush(r.client.Context(), r.ledgerKey(userID), values...).Err()
+}
+
+// Retrieve the full ledger of the user, oldest entry first.
+func (r *RedisClient) GetLedger(userID string) (entries []*LedgerEntry, err error) {
+	result, err := r.client.LRange(r.client.Context(), r.ledgerKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, raw := range result {
+		var entry LedgerEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err == nil {
+			entries = append(entries, &entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Delete the user record, appending any final entries to their ledger in the same
+// transaction.
+func (r *RedisClient) Delete(userID string, entries ...*LedgerEntry) {
+	r.client.TxPipelined(r.client.Context(), func(pipe redis.Pipeliner) error {
+		pipe.HDel(r.client.Context(), r.prefix, userID)
+		pipe.Incr(r.client.Context(), r.versionKey(userID))
+		for i := range entries {
+			entry, _ := json.Marshal(entries[i])
+			pipe.RPush(r.client.Context(), r.ledgerKey(userID), entry)
+		}
+		return nil
+	})
+}
+
+// Store a new user record, along with any ledger entries queued against it, unless one
+// already exists for the user. Returns whether the record was created.
+func (r *RedisClient) Create(userID string, value *User) (bool, error) {
+	ctx := r.client.Context()
+
+	var created bool
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, r.prefix, userID).Result()
+		if err != nil || exists {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.queueWrite(pipe, userID, value)
+			return nil
+		})
+		created = err == nil
 		return err
+	}, r.versionKey(userID))
+	if err == redis.TxFailedErr {
+		// Someone else wrote the record first.
+		return false, nil
+	}
+	if err != nil || !created {
+		return false, err
 	}
 
-	return nil
+	value.ledger = nil
+	return true, nil
 }
 
-func (r *RedisClient) Delete(userID string) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+// Optimistically update the user record; the record's version is watched while the
+// mutation is applied, and the write (along with any ledger entries queued by the
+// mutation) is discarded and retried if anything else modified it in the meantime. This
+// keeps updates atomic even across multiple bot processes sharing the same Redis, while
+// updates of different users never hold each other up.
+func (r *RedisClient) Update(userID string, mutate func(user *User) error) (*User, error) {
+	ctx := r.client.Context()
+
+	var user *User
+	transaction := func(tx *redis.Tx) error {
+		raw, err := tx.HGet(ctx, r.prefix, userID).Result()
+		if err != nil {
+			return err
+		}
+
+		user = &User{}
+		if err := json.Unmarshal([]byte(raw), user); err != nil {
+			return err
+		}
 
-	r.client.HDel(r.client.Context(), r.prefix, userID)
+		if err := mutate(user); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.queueWrite(pipe, userID, user)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < REDIS_UPDATE_RETRIES; attempt++ {
+		err := r.client.Watch(ctx, transaction, r.versionKey(userID))
+		if err == redis.TxFailedErr {
+			log.WithFields(log.Fields{
+				"user_id": userID,
+				"attempt": attempt + 1,
+			}).Debug("User record changed during update; retrying.")
+			time.Sleep(time.Duration(rand.Intn(20*(attempt+1))) * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		user.ledger = nil
+		return user, nil
+	}
+
+	return nil, fmt.Errorf("unable to update user %s after %d attempts", userID, REDIS_UPDATE_RETRIES)
 }
 
 func (r *RedisClient) Get(userID string) (*User, error) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-
 	raw := r.client.HGet(r.client.Context(), r.prefix, userID).Val()
 
 	var data User
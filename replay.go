@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Return a new ReplayFeed for the scripted price series in the specified file. The
+// script is either a JSON array, or a CSV file with a header row, of quote updates
+// using the same field names as the TradingView feed (`short_name`, `lp`,
+// `current_session`, ...). Like the TradingView feed, each update only needs to carry
+// the fields that changed since the previous update for that symbol.
+func NewReplayFeed(path string) (*ReplayFeed, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []json.RawMessage
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		frames, err = parseReplayCSV(string(raw))
+	} else {
+		err = json.Unmarshal(raw, &frames)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing replay script %s: %v", path, err)
+	}
+
+	return &ReplayFeed{
+		QuoteHub: NewQuoteHub(),
+		frames:   frames,
+		mutex:    &sync.Mutex{},
+	}, nil
+}
+
+// Convert each CSV row in to the JSON equivalent quote update; empty cells are left
+// out so they don't overwrite the previous value of that field.
+func parseReplayCSV(raw string) (frames []json.RawMessage, err error) {
+	rows, err := csv.NewReader(strings.NewReader(raw)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) < 1 {
+		return nil, nil
+	}
+
+	header := rows[0]
+	for _, row := range rows[1:] {
+		frame := map[string]interface{}{}
+		for i := range row {
+			if i >= len(header) || row[i] == "" {
+				continue
+			}
+
+			if value, err := strconv.ParseFloat(row[i], 64); err == nil {
+				frame[header[i]] = value
+			} else if value, err := strconv.ParseBool(row[i]); err == nil {
+				frame[header[i]] = value
+			} else {
+				frame[header[i]] = row[i]
+			}
+		}
+
+		data, _ := json.Marshal(frame)
+		frames = append(frames, data)
+	}
+
+	return frames, nil
+}
+
+// Apply the next update in the script, notifying any subscribers of the symbol.
+// Returns false once the script is exhausted.
+func (r *ReplayFeed) Step() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.position >= len(r.frames) {
+		return false
+	}
+
+	frame := r.frames[r.position]
+	r.position = r.position + 1
+
+	var update TradingViewQuote
+	if err := json.Unmarshal(frame, &update); err != nil || update.Symbol == "" {
+		log.Errorf("Skipping unusable replay frame %d: %s", r.position, string(frame))
+		return true
+	}
+
+	quote, _ := r.GetCurrent(update.Symbol)
+	json.Unmarshal(frame, &quote)
+
+	r.publish(quote.Symbol, quote)
+
+	return true
+}
+
+// Play back the whole script, applying an update every interval.
+func (r *ReplayFeed) Play(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !r.Step() {
+			log.Info("Quote replay finished.")
+			return
+		}
+	}
+}
+
+// Scripted symbols are always available, so watching is a no-op beyond making sure
+// the symbol is known.
+func (r *ReplayFeed) Watch(symbol string) {
+	r.track(symbol)
+}
+
+func (r *ReplayFeed) GetQuote(symbol string, callback func(TradingViewQuote) (shouldDelete bool)) {
+	if quote, ok := r.GetCurrent(symbol); ok && !quote.ReceivedAt.IsZero() {
+		callback(quote)
+		return
+	}
+
+	r.OnUpdate(symbol, callback)
+}
+
+func (r *ReplayFeed) OnUpdate(symbol string, callback func(TradingViewQuote) (shouldDelete bool)) {
+	r.subscribe(symbol, callback)
+	r.Watch(symbol)
+}
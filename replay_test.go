@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeReplayScript(t *testing.T, name string, script string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewReplayFeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		script string
+		err    bool
+		quotes map[string]TradingViewQuote
+	}{
+		{
+			name: "json",
+			file: "quotes.json",
+			script: `[
+				{"short_name": "AAPL", "lp": 120, "current_session": "market", "volume": 1000},
+				{"short_name": "MSFT", "lp": 300.5, "is_tradable": true},
+				{"short_name": "AAPL", "lp": 121}
+			]`,
+			quotes: map[string]TradingViewQuote{
+				"AAPL": {Symbol: "AAPL", LastPrice: 121, CurrentSession: "market", Volume: 1000},
+				"MSFT": {Symbol: "MSFT", LastPrice: 300.5, IsTradable: true},
+			},
+		},
+		{
+			name: "csv",
+			file: "quotes.CSV",
+			script: "short_name,lp,current_session,volume,is_tradable\n" +
+				"AAPL,120,market,1000,\n" +
+				"MSFT,300.5,,,true\n" +
+				"AAPL,121,,,\n",
+			quotes: map[string]TradingViewQuote{
+				"AAPL": {Symbol: "AAPL", LastPrice: 121, CurrentSession: "market", Volume: 1000},
+				"MSFT": {Symbol: "MSFT", LastPrice: 300.5, IsTradable: true},
+			},
+		},
+		{
+			name:   "csv header only",
+			file:   "quotes.csv",
+			script: "short_name,lp\n",
+			quotes: map[string]TradingViewQuote{},
+		},
+		{
+			name:   "invalid json",
+			file:   "quotes.json",
+			script: `{"short_name": "AAPL"}`,
+			err:    true,
+		},
+		{
+			name:   "ragged csv",
+			file:   "quotes.csv",
+			script: "short_name,lp\nAAPL,120,market\n",
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		feed, err := NewReplayFeed(writeReplayScript(t, test.file, test.script))
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		for feed.Step() {
+		}
+
+		quotes := map[string]TradingViewQuote{}
+		for _, symbol := range feed.Symbols() {
+			quote, _ := feed.GetCurrent(symbol)
+			quote.ReceivedAt, quote.TradedVolume = time.Time{}, 0
+			quotes[symbol] = quote
+		}
+		if !reflect.DeepEqual(quotes, test.quotes) {
+			t.Errorf("%s: got %+v, want %+v", test.name, quotes, test.quotes)
+		}
+	}
+
+	if _, err := NewReplayFeed(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing script didn't return an error")
+	}
+}
+
+func TestParseReplayCSV(t *testing.T) {
+	frames, err := parseReplayCSV("short_name,lp,is_tradable,current_session\n" +
+		"AAPL,120.25,true,market\n" +
+		"AAPL,,,\n" +
+		"MSFT,1e2,false,pre_market\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]interface{}{
+		{"short_name": "AAPL", "lp": 120.25, "is_tradable": true, "current_session": "market"},
+		{"short_name": "AAPL"},
+		{"short_name": "MSFT", "lp": 100.0, "is_tradable": false, "current_session": "pre_market"},
+	}
+	if len(frames) != len(expected) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(expected))
+	}
+	for i, frame := range frames {
+		var fields map[string]interface{}
+		json.Unmarshal(frame, &fields)
+		if !reflect.DeepEqual(fields, expected[i]) {
+			t.Errorf("frame %d: got %v, want %v", i, fields, expected[i])
+		}
+	}
+
+	if frames, err := parseReplayCSV(""); frames != nil || err != nil {
+		t.Errorf("empty script: got %v, %v", frames, err)
+	}
+}
+
+func TestReplayPlayback(t *testing.T) {
+	feed, err := NewReplayFeed(writeReplayScript(t, "quotes.json", `[
+		{"short_name": "AAPL", "lp": 120, "current_session": "market", "volume": 1000},
+		{"short_name": "MSFT", "lp": 300},
+		{"lp": 1},
+		{"short_name": "AAPL", "lp": 121, "volume": 1500},
+		{"short_name": "AAPL", "lp": 122},
+		{"short_name": "MSFT", "lp": 301}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record each call, asking to be deleted after the given number of quotes, or
+	// never if it is 0.
+	var calls []string
+	record := func(name string, remaining int) func(TradingViewQuote) bool {
+		return func(quote TradingViewQuote) bool {
+			calls = append(calls, fmt.Sprintf("%s %s %v %s %v", name, quote.Symbol, quote.LastPrice, quote.CurrentSession, quote.TradedVolume))
+			remaining = remaining - 1
+			return remaining == 0
+		}
+	}
+
+	feed.OnUpdate("AAPL", record("first", 0))
+	feed.OnUpdate("AAPL", record("once", 1))
+	feed.GetQuote("MSFT", record("get", 1))
+	if !feed.IsWatching("AAPL") || !feed.IsWatching("MSFT") {
+		t.Error("subscribing didn't watch the symbols")
+	}
+
+	steps := 0
+	for feed.Step() {
+		steps = steps + 1
+	}
+	if steps != 6 {
+		t.Errorf("stepped %d times, want 6", steps)
+	}
+
+	// "once" and "get" are dropped after their first quote, the frame without a symbol
+	// is skipped, partial updates keep the fields they leave out, and the AAPL volume
+	// traded between updates is passed along.
+	expected := []string{
+		"first AAPL 120 market 0",
+		"once AAPL 120 market 0",
+		"get MSFT 300  0",
+		"first AAPL 121 market 500",
+		"first AAPL 122 market 0",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("got callbacks\n%v\nwant\n%v", calls, expected)
+	}
+
+	// Once a quote has arrived, GetQuote answers straight away.
+	calls = nil
+	feed.GetQuote("MSFT", record("get", 1))
+	if !reflect.DeepEqual(calls, []string{"get MSFT 301  0"}) {
+		t.Errorf("got callbacks %v for a known quote", calls)
+	}
+
+	if feed.Step() {
+		t.Error("stepped past the end of the script")
+	}
+}
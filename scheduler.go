@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A job run for every player once a day, after the close. Run is passed the day it runs
+// for, and the number of days since it last ran for the player; zero the first time, so
+// jobs that accrue by the day can start from there.
+type DailyJob struct {
+	Name string
+	Run  func(user *User, through time.Time, days int, session string)
+}
+
+var DailyJobs = []DailyJob{
+	{
+		Name: "borrow_fees",
+		Run: func(user *User, through time.Time, days int, session string) {
+			user.accrueBorrowFees(through, session)
+		},
+	},
+	{
+		Name: "interest",
+		Run: func(user *User, through time.Time, days int, session string) {
+			user.accrueInterest(days, session)
+		},
+	},
+}
+
+// Returns the day that daily jobs at the given time run for; the last trading day whose
+// session has closed, early or not. Jobs run after the close, so each day runs from one
+// close to the next.
+func accrualDate(t time.Time) time.Time {
+	t = t.In(calendar.Location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, calendar.Location)
+	for !calendar.IsTradingDay(day) || t.Before(calendar.CloseOn(day)) {
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return day
+}
+
+// Apply corporate actions coming into effect and run the daily jobs, then do so again
+// after each close of the regular session, and once trading has ended for the day.
+// Every player keeps track of the last day each job ran for them, updated along with
+// whatever the job changed, so jobs missed while the bot was down catch up on the next
+// run, and several bots can run them at once without any player being charged or paid
+// twice.
+func RunScheduler() {
+	for {
+		ApplyCorporateActions(time.Now())
+		RunDailyJobs(time.Now())
+
+		next := nextSchedulerRun(time.Now())
+		log.WithField("next_run", calendar.Format(next)).Debug("Scheduled next daily job run.")
+		time.Sleep(time.Until(next) + time.Second)
+	}
+}
+
+// Returns when the scheduler runs next after the given time; the end of trading for the
+// day if the regular session has closed but post market is still trading, otherwise
+// the next close.
+func nextSchedulerRun(now time.Time) time.Time {
+	if ends := calendar.TradingEndsOn(accrualDate(now)); ends.After(now) {
+		return ends
+	}
+
+	return calendar.CloseOn(calendar.TradingDate(now))
+}
+
+// Run each daily job that hasn't run yet for the day at the given time, for every player.
+func RunDailyJobs(now time.Time) {
+	through := accrualDate(now)
+	day := through.Format(CALENDAR_DATE_FORMAT)
+	session := calendar.Session(now)
+
+	Storage.ForEach(func(user User) {
+		for i := range DailyJobs {
+			job := DailyJobs[i]
+			if user.JobsRun[job.Name] >= day {
+				continue
+			}
+
+			err := user.Update(func(user *User) error {
+				last := user.JobsRun[job.Name]
+				if last >= day {
+					return nil
+				}
+
+				days := 0
+				if parsed, err := time.ParseInLocation(CALENDAR_DATE_FORMAT, last, calendar.Location); err == nil {
+					days = int(math.Round(through.Sub(parsed).Hours() / 24))
+				}
+
+				job.Run(user, through, days, session)
+
+				if user.JobsRun == nil {
+					user.JobsRun = make(map[string]string)
+				}
+				user.JobsRun[job.Name] = day
+				return nil
+			})
+
+			if err != nil {
+				user.log(map[string]interface{}{
+					"method": "RunDailyJobs",
+					"job":    job.Name,
+					"day":    day,
+				}).WithError(err).Error("Unable to run daily job.")
+			}
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns the time of day on the given day, in exchange time.
+func exchangeTime(t *testing.T, day string, hour, minute int) time.Time {
+	date, err := time.ParseInLocation(CALENDAR_DATE_FORMAT, day, calendar.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return date.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func TestAccrualDate(t *testing.T) {
+	at := func(day string, hour, minute int) time.Time {
+		return exchangeTime(t, day, hour, minute)
+	}
+
+	tests := []struct {
+		name string
+		time time.Time
+		want string
+	}{
+		{name: "after the close", time: at("2026-10-14", 16, 1), want: "2026-10-14"},
+		{name: "before the close", time: at("2026-10-14", 15, 59), want: "2026-10-13"},
+		{name: "after midnight", time: at("2026-10-15", 0, 30), want: "2026-10-14"},
+		{name: "weekend", time: at("2026-10-17", 12, 0), want: "2026-10-16"},
+		{name: "monday morning", time: at("2026-10-19", 9, 0), want: "2026-10-16"},
+		{name: "after an early close", time: at("2026-11-27", 13, 1), want: "2026-11-27"},
+		{name: "before an early close", time: at("2026-11-27", 12, 59), want: "2026-11-25"},
+	}
+
+	for _, test := range tests {
+		if got := accrualDate(test.time).Format(CALENDAR_DATE_FORMAT); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNextSchedulerRun(t *testing.T) {
+	defer func(saved Settings) { *settings = saved }(*settings)
+
+	at := func(day string, hour, minute int) time.Time {
+		return exchangeTime(t, day, hour, minute)
+	}
+
+	tests := []struct {
+		name     string
+		extended bool
+		time     time.Time
+		want     time.Time
+	}{
+		{name: "during the session", extended: true, time: at("2026-10-14", 11, 0), want: at("2026-10-14", 16, 0)},
+		{name: "during post market", extended: true, time: at("2026-10-14", 17, 0), want: at("2026-10-14", 20, 0)},
+		{name: "after post market", extended: true, time: at("2026-10-14", 20, 1), want: at("2026-10-15", 16, 0)},
+		{name: "during pre market", extended: true, time: at("2026-10-15", 5, 0), want: at("2026-10-15", 16, 0)},
+		{name: "weekend", extended: true, time: at("2026-10-17", 12, 0), want: at("2026-10-19", 16, 0)},
+		{name: "post market of an early close", extended: true, time: at("2026-11-27", 14, 0), want: at("2026-11-27", 17, 0)},
+		{name: "after the close without extended hours", time: at("2026-10-14", 17, 0), want: at("2026-10-15", 16, 0)},
+	}
+
+	for _, test := range tests {
+		settings.ExtendedHours = test.extended
+		if got := nextSchedulerRun(test.time); !got.Equal(test.want) {
+			t.Errorf("%s: got %s, want %s", test.name, calendar.Format(got), calendar.Format(test.want))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Game settings, configured through the environment.
+type Settings struct {
+	// The oldest a quote may be, per TradingView session, before it is considered stale
+	// and refused for trading. A zero duration means quotes never go stale in that
+	// session.
+	QuoteMaxAge map[string]time.Duration
+
+	// Whether players may trade during pre and post market, or only during the regular
+	// session.
+	ExtendedHours bool
+
+	// The slippage market orders are filled with, against the player; half the bid/ask
+	// spread, as a fraction of the price, plus a share of the day's percentage move as
+	// a stand-in for the stock's volatility. Both default to zero, filling at the quote.
+	Spread         float64
+	SlippageFactor float64
+
+	// The share of the volume traded between quote updates an order may fill against in
+	// each update, in lots of LotSize shares; what doesn't fill keeps working. Zero fills
+	// orders in one go, whatever their size.
+	Participation float64
+	LotSize       int
+
+	// The number of decimals players can trade shares in; zero for whole shares only.
+	SharePrecision int
+
+	// Whether players trade on margin. Short proceeds are credited to their funds, they
+	// may borrow against their equity for up to 1/InitialMargin times it in positions,
+	// and once their equity drops below MaintenanceMargin of the value of their
+	// positions they get a margin call, and positions are liquidated.
+	Margin            bool
+	InitialMargin     float64
+	MaintenanceMargin float64
+
+	// The annual rates charged for borrowing the shares of shorts, by default and for
+	// symbols that are hard to borrow. Symbols can have rates of their own, from the
+	// borrow rates file.
+	BorrowRate       float64
+	HardToBorrowRate float64
+
+	// The annual interest rates paid on idle funds, and charged on negative balances
+	// when trading on margin. Interest is paid or charged daily.
+	CashInterestRate   float64
+	MarginInterestRate float64
+
+	// The Slack IDs of the players allowed to use admin commands.
+	Admins map[string]bool
+}
+
+var settings = LoadSettings()
+
+func LoadSettings() *Settings {
+	return &Settings{
+		QuoteMaxAge: map[string]time.Duration{
+			"market":      envDuration("QUOTE_MAX_AGE_MARKET", 5*time.Minute),
+			"pre_market":  envDuration("QUOTE_MAX_AGE_PRE_MARKET", 30*time.Minute),
+			"post_market": envDuration("QUOTE_MAX_AGE_POST_MARKET", 30*time.Minute),
+			"closed":      envDuration("QUOTE_MAX_AGE_CLOSED", 0),
+		},
+		ExtendedHours:  envBool("EXTENDED_HOURS_TRADING", true),
+		Spread:         envFloat("SPREAD_BPS", 0) / 10000,
+		SlippageFactor: envFloat("SLIPPAGE_VOLATILITY_FACTOR", 0),
+		Participation:  envFloat("FILL_PARTICIPATION", 0),
+		LotSize:        envInt("FILL_LOT_SIZE", 1, 1),
+		SharePrecision: envInt("SHARE_PRECISION", 4, 0),
+
+		Margin:            envBool("MARGIN_ACCOUNTS", false),
+		InitialMargin:     envFloat("MARGIN_INITIAL", 0.5),
+		MaintenanceMargin: envFloat("MARGIN_MAINTENANCE", 0.3),
+
+		BorrowRate:       envFloat("BORROW_RATE", 0),
+		HardToBorrowRate: envFloat("HARD_TO_BORROW_RATE", 0.3),
+
+		CashInterestRate:   envFloat("CASH_INTEREST_RATE", 0),
+		MarginInterestRate: envFloat("MARGIN_INTEREST_RATE", 0.08),
+
+		Admins: envSet("ADMIN_USERS"),
+	}
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Errorf("Invalid duration for %s (%q), using %v: %v", name, value, fallback, err)
+		return fallback
+	}
+
+	return duration
+}
+
+func envBool(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Errorf("Invalid boolean for %s (%q), using %v: %v", name, value, fallback, err)
+		return fallback
+	}
+
+	return parsed
+}
+
+func envFloat(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Errorf("Invalid number for %s (%q), using %v: %v", name, value, fallback, err)
+		return fallback
+	}
+
+	return parsed
+}
+
+func envInt(name string, fallback int, minimum int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < minimum {
+		log.Errorf("Invalid count for %s (%q), using %v: %v", name, value, fallback, err)
+		return fallback
+	}
+
+	return parsed
+}
+
+func envSet(name string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			set[value] = true
+		}
+	}
+
+	return set
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Returns the shares rounded to the number of decimals players trade in, dropping the
+// error floating point arithmetic leaves behind.
+func roundShares(quantity float64) float64 {
+	scale := math.Pow10(settings.SharePrecision)
+	return math.Round(quantity*scale) / scale
+}
+
+// Returns the shares rounded down to the number of decimals players trade in; as many
+// as can be had for an amount, or are left after a split.
+func floorShares(quantity float64) float64 {
+	scale := math.Pow10(settings.SharePrecision)
+	return math.Floor(quantity*scale+0.000001) / scale
+}
+
+// Returns the shares rounded up to the number of decimals players trade in; enough to
+// make up at least the quantity.
+func ceilShares(quantity float64) float64 {
+	scale := math.Pow10(settings.SharePrecision)
+	return math.Ceil(quantity*scale-0.000001) / scale
+}
+
+// Format a number of shares for messages to users; whole shares without decimals, and
+// fractional shares with as many as they need.
+func formatShares(quantity float64) string {
+	return humanize.Commaf(roundShares(quantity))
+}
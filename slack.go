@@ -23,6 +23,7 @@ func SlackEventRouter() *mux.Router {
 	router := mux.NewRouter()
 
 	router.HandleFunc("/slack/events", SlackEventHandler)
+	router.HandleFunc("/health", HealthHandler)
 
 	return router
 }
@@ -115,7 +116,7 @@ func SlackMessageHandler(event *slackevents.MessageEvent) {
 			symbol := strings.ToUpper(symbols[i][1])
 			seen[symbol] = true
 
-			tradingview.GetQuote(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
+			quotes.GetQuote(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
 				if quote.Symbol != symbol {
 					slackapi.PostMessage(
 						event.Channel,
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var Storage Store
+
+// Set up the storage backend selected by STORAGE_BACKEND; `redis` (the default),
+// `memory` for a throwaway store that is lost on exit, or `file` to persist to the JSON
+// file named by STORAGE_FILE.
+func InitStorage() {
+	if Storage != nil {
+		return
+	}
+
+	switch strings.ToLower(os.Getenv("STORAGE_BACKEND")) {
+	case "memory":
+		log.Info("Using in-memory storage; nothing will be persisted.")
+		Storage = NewMemoryStore()
+	case "file":
+		path := os.Getenv("STORAGE_FILE")
+		if path == "" {
+			path = "stonkbot.json"
+		}
+
+		store, err := NewFileStore(path)
+		if err != nil {
+			panic(err)
+		}
+
+		log.Infof("Using file storage at %s.", path)
+		Storage = store
+	default:
+		Storage = NewRedisClient(RedisConfig{
+			RedisURL: os.Getenv("REDIS_URL"),
+			Prefix:   os.Getenv("REDIS_KEY_PREFIX"),
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type fileStoreContents struct {
+	Users   map[string]json.RawMessage
+	Ledgers map[string][]*LedgerEntry
+}
+
+// Return a store backed by a JSON file at the specified path, loading any existing
+// contents. Every write rewrites the whole file, so this is meant for local play rather
+// than a busy workspace.
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		path:        path,
+		mutex:       &sync.Mutex{},
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var contents fileStoreContents
+	if err := json.Unmarshal(raw, &contents); err != nil {
+		return nil, err
+	}
+
+	for userID, user := range contents.Users {
+		store.users[userID] = user
+	}
+	for userID, entries := range contents.Ledgers {
+		store.ledgers[userID] = entries
+	}
+
+	return store, nil
+}
+
+// Write the current contents of the store to disk, via a temporary file so a crash
+// mid-write never leaves a truncated store behind.
+func (f *FileStore) persist() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.MemoryStore.mutex.Lock()
+	contents := fileStoreContents{
+		Users:   make(map[string]json.RawMessage),
+		Ledgers: make(map[string][]*LedgerEntry),
+	}
+	for userID, user := range f.users {
+		contents.Users[userID] = user
+	}
+	for userID, entries := range f.ledgers {
+		contents.Ledgers[userID] = entries
+	}
+	data, err := json.Marshal(contents)
+	f.MemoryStore.mutex.Unlock()
+
+	if err != nil {
+		log.Errorf("Error serializing file store: %v", err)
+		return
+	}
+
+	temp := f.path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0600); err != nil {
+		log.Errorf("Error writing file store: %v", err)
+		return
+	}
+
+	if err := os.Rename(temp, f.path); err != nil {
+		log.Errorf("Error replacing file store: %v", err)
+	}
+}
+
+func (f *FileStore) Create(userID string, value *User) (bool, error) {
+	created, err := f.MemoryStore.Create(userID, value)
+	if created {
+		f.persist()
+	}
+
+	return created, err
+}
+
+func (f *FileStore) Update(userID string, mutate func(user *User) error) (*User, error) {
+	user, err := f.MemoryStore.Update(userID, mutate)
+	if err == nil {
+		f.persist()
+	}
+
+	return user, err
+}
+
+func (f *FileStore) Delete(userID string, entries ...*LedgerEntry) {
+	f.MemoryStore.Delete(userID, entries...)
+	f.persist()
+}
+
+func (f *FileStore) AppendLedger(userID string, entries ...*LedgerEntry) error {
+	f.MemoryStore.AppendLedger(userID, entries...)
+	f.persist()
+
+	return nil
+}
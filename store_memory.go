@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Return a new, empty, in-memory store. Users are kept serialized so callers never
+// share a record with the store, matching the semantics of the Redis backend.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:   make(map[string][]byte),
+		ledgers: make(map[string][]*LedgerEntry),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+func (m *MemoryStore) Get(userID string) (*User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.get(userID)
+}
+
+func (m *MemoryStore) get(userID string) (*User, error) {
+	raw, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user %s not found", userID)
+	}
+
+	var data User
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (m *MemoryStore) set(userID string, value *User) {
+	data, _ := json.Marshal(value)
+
+	m.users[userID] = data
+	m.ledgers[userID] = append(m.ledgers[userID], value.ledger...)
+	value.ledger = nil
+}
+
+func (m *MemoryStore) Create(userID string, value *User) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.users[userID]; ok {
+		return false, nil
+	}
+
+	m.set(userID, value)
+
+	return true, nil
+}
+
+func (m *MemoryStore) Update(userID string, mutate func(user *User) error) (*User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, err := m.get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mutate(user); err != nil {
+		return nil, err
+	}
+
+	m.set(userID, user)
+
+	return user, nil
+}
+
+func (m *MemoryStore) Delete(userID string, entries ...*LedgerEntry) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.users, userID)
+	m.ledgers[userID] = append(m.ledgers[userID], entries...)
+}
+
+func (m *MemoryStore) ForEach(callback func(user User)) {
+	for _, user := range m.GetAllUsers() {
+		callback(*user)
+	}
+}
+
+func (m *MemoryStore) GetAllUsers() (users []*User) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for userID := range m.users {
+		if user, err := m.get(userID); err == nil {
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
+
+func (m *MemoryStore) AppendLedger(userID string, entries ...*LedgerEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.ledgers[userID] = append(m.ledgers[userID], entries...)
+
+	return nil
+}
+
+func (m *MemoryStore) GetLedger(userID string) ([]*LedgerEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]*LedgerEntry{}, m.ledgers[userID]...), nil
+}
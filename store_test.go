@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// The backends every store test is run against.
+var testStores = []struct {
+	name string
+	new  func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store {
+		return NewMemoryStore()
+	}},
+	{"file", func(t *testing.T) Store {
+		store, err := NewFileStore(filepath.Join(t.TempDir(), "stonkbot.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}},
+}
+
+func TestStoreCreate(t *testing.T) {
+	for _, backend := range testStores {
+		store := backend.new(t)
+
+		user := &User{UserID: "U1", Funds: 100}
+		user.record(&LedgerEntry{Event: LedgerOpen, Amount: 100})
+		if created, err := store.Create("U1", user); !created || err != nil {
+			t.Errorf("%s: first create returned %v, %v", backend.name, created, err)
+		}
+		if user.ledger != nil {
+			t.Errorf("%s: ledger entries left queued on the user after they were written", backend.name)
+		}
+
+		if created, err := store.Create("U1", &User{UserID: "U1", Funds: 200}); created || err != nil {
+			t.Errorf("%s: second create returned %v, %v", backend.name, created, err)
+		}
+
+		stored, err := store.Get("U1")
+		if err != nil || stored.Funds != 100 {
+			t.Errorf("%s: got %+v, %v, want the first record", backend.name, stored, err)
+		}
+		if entries, _ := store.GetLedger("U1"); len(entries) != 1 {
+			t.Errorf("%s: got %d ledger entries, want 1", backend.name, len(entries))
+		}
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name    string
+		userID  string
+		mutate  func(user *User) error
+		err     bool
+		funds   float64
+		entries int
+	}{
+		{
+			name:   "applies the mutation",
+			userID: "U1",
+			mutate: func(user *User) error {
+				user.Funds = user.Funds - 40
+				user.record(&LedgerEntry{Event: LedgerBuy, Amount: -40})
+				return nil
+			},
+			funds:   60,
+			entries: 2,
+		},
+		{
+			name:   "discards a failed mutation",
+			userID: "U1",
+			mutate: func(user *User) error {
+				user.Funds = 0
+				user.record(&LedgerEntry{Event: LedgerBuy, Amount: -100})
+				return failed
+			},
+			err:     true,
+			funds:   100,
+			entries: 1,
+		},
+		{
+			name:   "unknown user",
+			userID: "U2",
+			mutate: func(user *User) error {
+				return nil
+			},
+			err:     true,
+			funds:   100,
+			entries: 1,
+		},
+	}
+
+	for _, backend := range testStores {
+		for _, test := range tests {
+			store := backend.new(t)
+			user := &User{UserID: "U1", Funds: 100}
+			user.record(&LedgerEntry{Event: LedgerOpen, Amount: 100})
+			store.Create("U1", user)
+
+			updated, err := store.Update(test.userID, test.mutate)
+			if (err != nil) != test.err {
+				t.Errorf("%s: %s: got error %v", backend.name, test.name, err)
+			}
+			if err == nil && updated.Funds != test.funds {
+				t.Errorf("%s: %s: returned funds %v, want %v", backend.name, test.name, updated.Funds, test.funds)
+			}
+
+			stored, _ := store.Get("U1")
+			if stored.Funds != test.funds {
+				t.Errorf("%s: %s: stored funds %v, want %v", backend.name, test.name, stored.Funds, test.funds)
+			}
+			if entries, _ := store.GetLedger("U1"); len(entries) != test.entries {
+				t.Errorf("%s: %s: got %d ledger entries, want %d", backend.name, test.name, len(entries), test.entries)
+			}
+		}
+	}
+}
+
+func TestStoreReturnsCopies(t *testing.T) {
+	for _, backend := range testStores {
+		store := backend.new(t)
+		store.Create("U1", &User{UserID: "U1", Funds: 100})
+
+		user, _ := store.Get("U1")
+		user.Funds = 0
+
+		if stored, _ := store.Get("U1"); stored.Funds != 100 {
+			t.Errorf("%s: changing a fetched user changed the store", backend.name)
+		}
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	for _, backend := range testStores {
+		store := backend.new(t)
+		store.Create("U1", &User{UserID: "U1", Funds: 100})
+		store.Create("U2", &User{UserID: "U2", Funds: 100})
+
+		store.Delete("U1", &LedgerEntry{Event: LedgerBankruptcy})
+
+		if _, err := store.Get("U1"); err == nil {
+			t.Errorf("%s: deleted user is still there", backend.name)
+		}
+		if _, err := store.Get("U2"); err != nil {
+			t.Errorf("%s: other user was deleted along with it", backend.name)
+		}
+		if entries, _ := store.GetLedger("U1"); len(entries) != 1 || entries[0].Event != LedgerBankruptcy {
+			t.Errorf("%s: got ledger %v, want the final bankruptcy entry kept", backend.name, entries)
+		}
+
+		if created, _ := store.Create("U1", &User{UserID: "U1"}); !created {
+			t.Errorf("%s: unable to create a deleted user again", backend.name)
+		}
+	}
+}
+
+func TestStoreForEach(t *testing.T) {
+	for _, backend := range testStores {
+		store := backend.new(t)
+		for _, userID := range []string{"U1", "U2", "U3"} {
+			store.Create(userID, &User{UserID: userID})
+		}
+		store.Delete("U2")
+
+		var visited []string
+		store.ForEach(func(user User) {
+			visited = append(visited, user.UserID)
+		})
+		sort.Strings(visited)
+
+		if len(visited) != 2 || visited[0] != "U1" || visited[1] != "U3" {
+			t.Errorf("%s: visited %v, want U1 and U3", backend.name, visited)
+		}
+		if users := store.GetAllUsers(); len(users) != 2 {
+			t.Errorf("%s: got %d users, want 2", backend.name, len(users))
+		}
+	}
+}
+
+func TestFileStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stonkbot.json")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &User{UserID: "U1", Funds: 100}
+	user.record(&LedgerEntry{Event: LedgerOpen, Amount: 100})
+	store.Create("U1", user)
+	store.Create("U2", &User{UserID: "U2", Funds: 100})
+	store.Update("U1", func(user *User) error {
+		user.Funds = 60
+		user.Portfolio = append(user.Portfolio, &Asset{ID: "a1", Type: "long", Symbol: "AAPL", CostBasis: 40, Quantity: 1})
+		user.record(&LedgerEntry{Event: LedgerBuy, LotID: "a1", Amount: -40})
+		return nil
+	})
+	store.Delete("U2")
+	store.AppendLedger("U2", &LedgerEntry{Event: LedgerOpen, Amount: 100})
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := reopened.Get("U1")
+	if err != nil || stored.Funds != 60 || len(stored.Portfolio) != 1 || stored.Portfolio[0].ID != "a1" {
+		t.Errorf("got %+v, %v, want the updated record", stored, err)
+	}
+	if _, err := reopened.Get("U2"); err == nil {
+		t.Error("deleted user came back")
+	}
+	if entries, _ := reopened.GetLedger("U1"); len(entries) != 2 || entries[1].Event != LedgerBuy {
+		t.Errorf("got ledger %v, want the open and buy entries", entries)
+	}
+	if entries, _ := reopened.GetLedger("U2"); len(entries) != 1 {
+		t.Errorf("got %d ledger entries for the deleted user, want 1", len(entries))
+	}
+}
+
+func TestFileStoreMissingFile(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if users := store.GetAllUsers(); len(users) != 0 {
+		t.Errorf("got %d users from a missing file, want none", len(users))
+	}
+}
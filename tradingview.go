@@ -18,95 +18,182 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	TradingViewDisconnected = "disconnected"
+	TradingViewConnecting   = "connecting"
+	TradingViewConnected    = "connected"
+)
+
+var TRADINGVIEW_BACKOFF_BASE = 1 * time.Second
+var TRADINGVIEW_BACKOFF_MAX = 2 * time.Minute
+var TRADINGVIEW_STABLE_AFTER = 1 * time.Minute
+
 // Return a new instance of a TradingView.
-func NewTradingView() TradingView {
-	return TradingView{
-		url: "wss://data.tradingview.com/socket.io/websocket",
+func NewTradingView() *TradingView {
+	return &TradingView{
+		QuoteHub: NewQuoteHub(),
+		url:      "wss://data.tradingview.com/socket.io/websocket",
 		requestHeader: http.Header{
 			"Origin": []string{
 				"https://data.tradingview.com/",
 			},
 		},
-		dialer:    &websocket.Dialer{},
-		sendMutex: &sync.Mutex{},
-		recvMutex: &sync.Mutex{},
-		Watching:  make(map[string]TradingViewQuote),
+		dialer:       &websocket.Dialer{},
+		sendMutex:    &sync.Mutex{},
+		recvMutex:    &sync.Mutex{},
+		stateMutex:   &sync.Mutex{},
+		state:        TradingViewDisconnected,
+		stateChanged: time.Now(),
 	}
 }
 
-// Connect the current instance of TradingView to the TradingView WebSocket.
+// Connect the current instance of TradingView to the TradingView WebSocket, and keep
+// it connected; whenever the connection can't be established or is lost, it will be
+// retried with jittered exponential backoff. On every (re)connection a fresh quote
+// session is created and every watched symbol is subscribed to again, so pending
+// notifications carry on receiving updates. Blocks forever; run it in a goroutine.
 func (tv *TradingView) Connect() {
-	var err error
-	var resp *http.Response
-
 	tv.dialer.TLSClientConfig = &tls.Config{
 		InsecureSkipVerify: true,
 	}
 
-	tv.conn, resp, err = tv.dialer.Dial(tv.url, tv.requestHeader)
+	attempt := 0
+	for {
+		tv.setState(TradingViewConnecting)
+
+		if err := tv.connect(); err != nil {
+			log.Errorf("Error while connecting to TradingView: %v", err)
+			tv.setState(TradingViewDisconnected)
+			if tv.OnConnectError != nil {
+				tv.OnConnectError(err, tv)
+			}
+		} else {
+			connected := time.Now()
+			err := tv.loop()
+			tv.conn.Close()
+
+			log.Errorf("Lost connection to TradingView: %v", err)
+			tv.setState(TradingViewDisconnected)
+			if tv.OnDisconnected != nil {
+				tv.OnDisconnected(err, tv)
+			}
+
+			if time.Since(connected) > TRADINGVIEW_STABLE_AFTER {
+				attempt = 0
+			}
+		}
+
+		delay := reconnectDelay(attempt)
+		attempt = attempt + 1
+
+		log.Infof("Reconnecting to TradingView in %v (attempt %d).", delay, attempt)
+		time.Sleep(delay)
+	}
+}
+
+func (tv *TradingView) connect() error {
+	conn, resp, err := tv.dialer.Dial(tv.url, tv.requestHeader)
 
 	if err != nil {
-		log.Errorf("Error while connecting to TradingView: %v", err)
 		if resp != nil {
 			log.Errorf("HTTP Response %d status: %s", resp.StatusCode, resp.Status)
 		}
-		tv.IsConnected = false
-		if tv.OnConnectError != nil {
-			tv.OnConnectError(err, *tv)
-		}
-		return
+		return err
 	}
 
-	log.Info("Connected to TradingView")
-
+	tv.sendMutex.Lock()
+	tv.conn = conn
 	tv.sessionID = createSessionID("qs_")
+	tv.sendMutex.Unlock()
+
+	log.Info("Connected to TradingView")
 
 	tv.send("set_data_quality", []interface{}{"low"})
 	tv.send("set_auth_token", []interface{}{"unauthorized_user_token"})
-	tv.send("quote_create_session", []interface{}{tv.sessionID})
-	tv.send("quote_set_fields", []interface{}{tv.sessionID, "listed_exchange",
+	tv.send("quote_create_session", []interface{}{tv.session()})
+	tv.send("quote_set_fields", []interface{}{tv.session(), "listed_exchange",
 		"ch", "chp", "rtc", "rch", "rchp", "lp", "is_tradable",
 		"short_name", "description", "currency_code", "current_session",
 		"status", "type", "update_mode", "fundamentals", "pro_name",
-		"original_name",
+		"original_name", "volume",
 	})
 
-	tv.IsConnected = true
-	if tv.OnConnected != nil {
-		tv.OnConnected(*tv)
+	tv.setState(TradingViewConnected)
+
+	// Anything watched before (or while) we were disconnected needs adding to the new
+	// quote session.
+	for _, symbol := range tv.Symbols() {
+		tv.addSymbol(symbol)
 	}
 
 	defaultCloseHandler := tv.conn.CloseHandler()
 	tv.conn.SetCloseHandler(func(code int, text string) error {
 		result := defaultCloseHandler(code, text)
 		log.Info("Disconnected from server", result)
-		if tv.OnDisconnected != nil {
-			tv.OnDisconnected(result, *tv)
-		}
 		return result
 	})
 
-	go tv.loop()
+	if tv.OnConnected != nil {
+		tv.OnConnected(tv)
+	}
+
+	return nil
 }
 
-func (tv *TradingView) loop() {
+// Read and handle messages until the connection fails.
+func (tv *TradingView) loop() error {
 	for {
 		tv.recvMutex.Lock()
 		_, message, err := tv.conn.ReadMessage()
 		tv.recvMutex.Unlock()
 
 		if err != nil {
-			if tv.OnDisconnected != nil {
-				tv.OnDisconnected(err, *tv)
-			} else {
-				panic("Lost connection; panic to restart")
-			}
+			return err
 		}
 
 		tv.messageHandler(string(message))
 	}
 }
 
+// Returns how long to wait before the next connection attempt; doubling with every
+// attempt up to a maximum, with up to half of it randomized so a fleet of bots doesn't
+// reconnect in lockstep.
+func reconnectDelay(attempt int) time.Duration {
+	delay := TRADINGVIEW_BACKOFF_MAX
+	if attempt < 16 {
+		delay = TRADINGVIEW_BACKOFF_BASE * time.Duration(1<<uint(attempt))
+	}
+	if delay > TRADINGVIEW_BACKOFF_MAX {
+		delay = TRADINGVIEW_BACKOFF_MAX
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (tv *TradingView) setState(state string) {
+	tv.stateMutex.Lock()
+	defer tv.stateMutex.Unlock()
+
+	if tv.state != state {
+		tv.state = state
+		tv.stateChanged = time.Now()
+	}
+}
+
+// Returns the state of the connection to TradingView, and when it entered that state.
+func (tv *TradingView) State() (state string, since time.Time) {
+	tv.stateMutex.Lock()
+	defer tv.stateMutex.Unlock()
+
+	return tv.state, tv.stateChanged
+}
+
+// Returns whether there is currently a live connection to TradingView.
+func (tv *TradingView) IsConnected() bool {
+	state, _ := tv.State()
+	return state == TradingViewConnected
+}
+
 func (tv *TradingView) messageHandler(message string) {
 	re := regexp.MustCompile("~m~[0-9]+~m~")
 	lines := re.Split(message, -1)
@@ -150,11 +237,12 @@ func (tv *TradingView) parseTradingViewEvent(message string) (err error) {
 			return err
 		}
 
+		// Strip the exchange from the symbol, keeping the share class of symbols such
+		// as NYSE:BRK.A.
 		symbol := envelope.Symbol
-		re := regexp.MustCompile(`([A-Z]+)$`)
-		parsed := re.FindStringSubmatch(symbol)
-		if len(parsed) == 2 {
-			symbol = parsed[0]
+		re := regexp.MustCompile(`[A-Z]+(?:\.[A-Z]+)?$`)
+		if parsed := re.FindString(symbol); parsed != "" {
+			symbol = parsed
 		}
 
 		var qsd TradingViewQuote
@@ -170,18 +258,14 @@ func (tv *TradingView) parseTradingViewEvent(message string) (err error) {
 		log.Debugf("QSD line %v", message)
 
 		if qsd.OriginalName != "" {
-			if _, ok := tv.Watching[qsd.OriginalName]; !ok {
-				tv.Watch(qsd.OriginalName)
-			}
+			tv.Watch(qsd.OriginalName)
 		}
 
 		if qsd.ProName != "" {
-			if _, ok := tv.Watching[qsd.ProName]; !ok {
-				tv.Watch(qsd.ProName)
-			}
+			tv.Watch(qsd.ProName)
 		}
 
-		tv.update(symbol, qsd)
+		tv.publish(symbol, qsd)
 	default:
 		log.Infof("Unknown TV payload: %v", message)
 		return nil
@@ -190,27 +274,6 @@ func (tv *TradingView) parseTradingViewEvent(message string) (err error) {
 	return nil
 }
 
-func (tv *TradingView) update(symbol string, quote TradingViewQuote) {
-	tv.Watching[symbol] = quote
-
-	temp := tv.notifications[:0]
-	for i := range tv.notifications {
-		notification := tv.notifications[i]
-
-		if notification.Symbol == symbol {
-			shouldDelete := notification.Action(quote)
-
-			if shouldDelete {
-				continue
-			}
-		}
-
-		temp = append(temp, notification)
-	}
-
-	tv.notifications = temp
-}
-
 func (tv *TradingView) send(method string, params []interface{}) {
 	data := TradingViewRequest{
 		Method: method,
@@ -239,36 +302,51 @@ func (tv *TradingView) sendSigned(message string) {
 
 func (tv *TradingView) sendRaw(message string) error {
 	tv.sendMutex.Lock()
-	err := tv.conn.WriteMessage(websocket.TextMessage, []byte(message))
-	tv.sendMutex.Unlock()
-	return err
+	defer tv.sendMutex.Unlock()
+
+	if tv.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	return tv.conn.WriteMessage(websocket.TextMessage, []byte(message))
+}
+
+// Returns the ID of the current quote session.
+func (tv *TradingView) session() string {
+	tv.sendMutex.Lock()
+	defer tv.sendMutex.Unlock()
+
+	return tv.sessionID
 }
 
 // Add the specified symbol to the TradingView watch list.
 func (tv *TradingView) Watch(symbol string) {
-	if _, ok := tv.Watching[symbol]; ok {
+	if !tv.track(symbol) {
 		return
-	} else {
-		tv.Watching[symbol] = TradingViewQuote{}
 	}
 
-	if tv.IsConnected {
-		tv.send("quote_add_symbols", []interface{}{
-			tv.sessionID,
-			symbol,
-			map[string][]string{
-				"flags": {
-					"force_permission",
-				},
-			},
-		})
-		tv.send("quote_fast_symbols", []interface{}{
-			tv.sessionID,
-			symbol,
-		})
+	if tv.IsConnected() {
+		tv.addSymbol(symbol)
 	}
 }
 
+// Add the symbol to the current quote session.
+func (tv *TradingView) addSymbol(symbol string) {
+	tv.send("quote_add_symbols", []interface{}{
+		tv.session(),
+		symbol,
+		map[string][]string{
+			"flags": {
+				"force_permission",
+			},
+		},
+	})
+	tv.send("quote_fast_symbols", []interface{}{
+		tv.session(),
+		symbol,
+	})
+}
+
 // Retrieve the specified symbols quote, with a callback for when the quote resolves.
 // If the symbol is already being watched, it will call the provided callback with the
 // last cached version of the symbol. If the symbol is not being watched yet, it will
@@ -277,43 +355,63 @@ func (tv *TradingView) Watch(symbol string) {
 // The callback should expect a TradingViewQuote struct containing the latest quote,
 // and should return a boolean specifying if it should continue to listen.
 func (tv *TradingView) GetQuote(symbol string, callback func(TradingViewQuote) (shouldDelete bool)) {
-	if _, ok := tv.Watching[symbol]; ok {
-		callback(tv.Watching[symbol])
+	if quote, ok := tv.GetCurrent(symbol); ok && !quote.ReceivedAt.IsZero() {
+		callback(quote)
 		return
 	}
 
 	tv.OnUpdate(symbol, callback)
 }
 
-// Retrieve the most recent quote for the specified symbol.
-// If the symbol is already being watched, it will return the latest quote available,
-// and a true value to indicate this request was successful.
-// If the symbol is not watched, it will return an empty TradingViewQuote struct, and
-// a false value to indicate the quote is not being watched.
-func (tv *TradingView) GetCurrent(symbol string) (quote TradingViewQuote, ok bool) {
-	if _, ok := tv.Watching[symbol]; ok {
-		return tv.Watching[symbol], true
-	}
-
-	return TradingViewQuote{}, false
-}
-
 // Create a notification request for all updates to the specified symbol which will
 // call the specified callback for each update event. The callback should expect a
 // TradingViewQuote struct containing the quote that triggered this call. The callback
 // should return a boolean specifying if it should continue to notify this callback
 // on updates of the symbol.
 func (tv *TradingView) OnUpdate(symbol string, callback func(TradingViewQuote) (shouldDelete bool)) {
-	if _, ok := tv.Watching[symbol]; !ok {
-		tv.Watch(symbol)
+	tv.subscribe(symbol, callback)
+	tv.Watch(symbol)
+}
+
+// Returns the price a trade would execute at right now; the extended hours price
+// when in pre or post market, otherwise the last price.
+func (q TradingViewQuote) Price() float64 {
+	if q.LivePrice != 0.00 && (q.CurrentSession == "pre_market" || q.CurrentSession == "post_market") {
+		return q.LivePrice
 	}
 
-	notification := &TradingViewNotifications{
-		Symbol: symbol,
-		Action: callback,
+	return q.LastPrice
+}
+
+// Returns how long ago the quote was received.
+func (q TradingViewQuote) Age() time.Duration {
+	return time.Since(q.ReceivedAt)
+}
+
+// Returns whether the quote is too old to trade on, based on the maximum age configured
+// for the session the quote is in. Placeholder quotes that were never received are
+// always stale.
+func (q TradingViewQuote) IsStale() bool {
+	if q.ReceivedAt.IsZero() {
+		return true
+	}
+
+	session := q.CurrentSession
+	if _, ok := settings.QuoteMaxAge[session]; !ok {
+		session = "closed"
+	}
+
+	max := settings.QuoteMaxAge[session]
+	return max > 0 && q.Age() > max
+}
+
+// Returns a message explaining to the user why the quote can't be used.
+func (q TradingViewQuote) StaleMessage(symbol string) string {
+	if q.ReceivedAt.IsZero() {
+		return fmt.Sprintf("I don't have a live quote for %s right now, so I can't trade it. Please try again in a moment.", symbol)
 	}
 
-	tv.notifications = append(tv.notifications, notification)
+	return fmt.Sprintf("The latest quote I have for %s is %v old, which is too stale to trade on. Please try again in a moment.", symbol, q.Age().Round(time.Second))
 }
 
 func createSessionID(prefix string) string {
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseTradingViewQuoteEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		symbol string
+		want   string
+	}{
+		{name: "exchange prefix", symbol: "NASDAQ:AAPL", want: "AAPL"},
+		{name: "share class", symbol: "NYSE:BRK.A", want: "BRK.A"},
+		{name: "share class without exchange", symbol: "BF.B", want: "BF.B"},
+		{name: "bare symbol", symbol: "TSLA", want: "TSLA"},
+	}
+
+	for _, test := range tests {
+		tv := NewTradingView()
+		message := `{"m":"qsd","p":["qs_test",{"n":"` + test.symbol + `","s":"ok","v":{"short_name":"` + test.want + `","lp":712000.5}}]}`
+		if err := tv.parseTradingViewEvent(message); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		quote, ok := tv.GetCurrent(test.want)
+		if !ok {
+			t.Errorf("%s: no quote published under %s; watching %v", test.name, test.want, tv.Symbols())
+			continue
+		}
+		if quote.LastPrice != 712000.5 {
+			t.Errorf("%s: got last price %v, want 712000.5", test.name, quote.LastPrice)
+		}
+	}
+}
+
+func TestParseTradingViewEventKeepsEarlierFields(t *testing.T) {
+	tv := NewTradingView()
+	messages := []string{
+		`{"m":"qsd","p":["qs_test",{"n":"NYSE:BRK.A","s":"ok","v":{"short_name":"BRK.A","lp":712000,"ch":1200}}]}`,
+		`{"m":"qsd","p":["qs_test",{"n":"NYSE:BRK.A","s":"ok","v":{"lp":712500}}]}`,
+	}
+	for i := range messages {
+		if err := tv.parseTradingViewEvent(messages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	quote, _ := tv.GetCurrent("BRK.A")
+	if quote.LastPrice != 712500 || quote.Change != 1200 || quote.Symbol != "BRK.A" {
+		t.Errorf("got %+v, want the latest price merged into the earlier quote", quote)
+	}
+	if _, ok := tv.GetCurrent("A"); ok {
+		t.Error("quote for BRK.A was published under A")
+	}
+}
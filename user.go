@@ -1,13 +1,19 @@
 package main
 
 import (
-	"math"
+	"encoding/json"
+	"errors"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 var DEFAULT_WALLET_VALUE = float64(1000000)
+var REALIZED_DAY_FORMAT = "2006-01-02"
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+var ErrNoMatchingPosition = errors.New("no matching position")
 
 type User struct {
 	UserID    string
@@ -15,21 +21,112 @@ type User struct {
 	Funds     float64
 	HeldFunds float64
 	Portfolio []*Asset
+	Orders    []*Order `json:",omitempty"`
+
+	// How lots are picked when a position is only partly closed; see LotMethods.
+	LotMethod string `json:",omitempty"`
+
+	// The last day each of the daily jobs ran for the user; see DailyJobs.
+	JobsRun map[string]string `json:",omitempty"`
+
+	// The IDs of the corporate actions applied to the user's lots and orders.
+	CorporateActions []string `json:",omitempty"`
+
+	RealizedGains    float64
+	RealizedBySymbol map[string]float64
+	RealizedByDay    map[string]float64
+
+	ledger []*LedgerEntry
 }
 
 type Asset struct {
+	ID        string `json:",omitempty"`
 	Type      string
 	Symbol    string
 	CostBasis float64
-	Quantity  int
+	Quantity  float64
+	Reserved  float64 `json:",omitempty"`
+
+	// Shorts opened on margin were credited their proceeds, where others had their value
+	// set aside from funds until covered.
+	Margin bool `json:",omitempty"`
+
+	// The borrow fees charged on a short so far, and the last day they were charged for.
+	BorrowFees  float64 `json:",omitempty"`
+	FeesThrough string  `json:",omitempty"`
+
+	// The trading day the lot was opened for; corporate actions only apply to lots
+	// opened before their ex-date.
+	Opened string `json:",omitempty"`
+}
+
+// Returns the number of shares of the lot that aren't reserved for a pending order.
+func (a *Asset) Available() float64 {
+	return roundShares(a.Quantity - a.Reserved)
+}
+
+// Records stored before orders had a model of their own kept pending orders in the
+// portfolio, as assets with an order type; move any of those over to the user's orders
+// as the record is loaded.
+func (u *User) UnmarshalJSON(data []byte) error {
+	type stored User
+	var record struct {
+		*stored
+		Portfolio []*struct {
+			Asset
+			Limit        float64
+			Trail        float64
+			TrailPercent bool
+			HighWater    float64
+			TimeInForce  string
+			Expires      string
+		}
+	}
+	record.stored = (*stored)(u)
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+
+	u.Portfolio = nil
+	for i, asset := range record.Portfolio {
+		if !isOrder(asset.Type) {
+			position := asset.Asset
+			if position.ID == "" {
+				position.ID = legacyID(i, position.Type, position.Symbol, position.Quantity, position.CostBasis)
+			}
+			u.Portfolio = append(u.Portfolio, &position)
+			continue
+		}
+
+		u.Orders = append(u.Orders, &Order{
+			ID:           u.legacyOrderID(asset.Type, asset.Symbol, asset.Quantity, asset.CostBasis),
+			Owner:        u.UserID,
+			Type:         asset.Type,
+			Symbol:       asset.Symbol,
+			Quantity:     asset.Quantity,
+			Target:       asset.CostBasis,
+			Limit:        asset.Limit,
+			Trail:        asset.Trail,
+			TrailPercent: asset.TrailPercent,
+			HighWater:    asset.HighWater,
+			TimeInForce:  asset.TimeInForce,
+			Expires:      asset.Expires,
+			Status:       OrderOpen,
+		})
+	}
+
+	return nil
 }
 
 func GetUserByID(userID string) *User {
-	if user, err := Redis.Get(userID); err == nil {
+	if user, err := Storage.Get(userID); err == nil {
 		if user.FullName == "" {
 			slackuser, _ := slackapi.GetUserInfo(userID)
-			user.FullName = slackuser.Profile.RealName
-			user.Save()
+			user.Update(func(user *User) error {
+				user.FullName = slackuser.Profile.RealName
+				return nil
+			})
 		}
 		return user
 	} else {
@@ -39,8 +136,17 @@ func GetUserByID(userID string) *User {
 			FullName: slackuser.Profile.RealName,
 			Funds:    DEFAULT_WALLET_VALUE,
 		}
+		user.record(&LedgerEntry{
+			Event:  LedgerOpen,
+			Amount: DEFAULT_WALLET_VALUE,
+		})
 
-		Redis.Set(userID, user)
+		if created, err := Storage.Create(userID, user); err == nil && !created {
+			// Another message from the same user beat us to creating the record.
+			if existing, err := Storage.Get(userID); err == nil {
+				return existing
+			}
+		}
 		return user
 	}
 }
@@ -59,62 +165,239 @@ func (u *User) log(fields ...map[string]interface{}) *log.Entry {
 	return output
 }
 
-func (u *User) Save() {
-	u.log(map[string]interface{}{
-		"funds":      u.Funds,
-		"held_funds": u.HeldFunds,
-	}).Info("Saving user record.")
-	Redis.Set(u.UserID, u)
+// Atomically apply the mutation to the latest stored copy of the user, retrying if the
+// record changed underneath us. On success u is refreshed with the stored result. The
+// mutation may be run more than once, so it should only touch the user passed to it.
+func (u *User) Update(mutate func(user *User) error) error {
+	user, err := Storage.Update(u.UserID, mutate)
+	if err != nil {
+		return err
+	}
+
+	user.log(map[string]interface{}{
+		"funds":      user.Funds,
+		"held_funds": user.HeldFunds,
+	}).Info("Saved user record.")
+
+	*u = *user
+	return nil
 }
 
-func (u *User) CreatePosition(position_type string, symbol string, quantity int64, target float64, source *Command) {
-	user := u
-	tradingview.GetQuote(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
-		user = GetUserByID(user.UserID)
+// Book a realized gain (or loss) from closing a long or short lot.
+func (u *User) realize(symbol string, gain float64, when time.Time) {
+	if u.RealizedBySymbol == nil {
+		u.RealizedBySymbol = make(map[string]float64)
+	}
+	if u.RealizedByDay == nil {
+		u.RealizedByDay = make(map[string]float64)
+	}
 
+	day := when.Format(REALIZED_DAY_FORMAT)
+
+	u.RealizedGains = u.RealizedGains + gain
+	u.RealizedBySymbol[symbol] = u.RealizedBySymbol[symbol] + gain
+	u.RealizedByDay[day] = u.RealizedByDay[day] + gain
+}
+
+// Sum the realized gains booked on or after the specified day.
+func (u *User) RealizedSince(since time.Time) (gains float64) {
+	start := since.Format(REALIZED_DAY_FORMAT)
+	for day, gain := range u.RealizedByDay {
+		if day >= start {
+			gains = gains + gain
+		}
+	}
+
+	return gains
+}
+
+// Open a new lot of the specified type at the specified price, taking funds to cover
+// it (or on margin, crediting the proceeds of shorts) and recording it in the ledger.
+func (u *User) open(position_type string, symbol string, quantity float64, price float64, session string) (*Asset, error) {
+	asset := &Asset{
+		ID:        u.newLotID(),
+		Type:      position_type,
+		Symbol:    symbol,
+		CostBasis: price,
+		Quantity:  quantity,
+		Opened:    calendar.TradingDate(time.Now()).Format(CALENDAR_DATE_FORMAT),
+	}
+
+	cost := price * quantity
+	if cost > u.available() {
+		return nil, ErrInsufficientFunds
+	}
+
+	entry := &LedgerEntry{
+		LotID:    asset.ID,
+		Type:     position_type,
+		Symbol:   symbol,
+		Quantity: quantity,
+		Price:    price,
+		Basis:    price,
+		Amount:   -cost,
+		Session:  session,
+	}
+
+	switch position_type {
+	case "long":
+		entry.Event = LedgerBuy
+	case "short":
+		entry.Event = LedgerShort
+		asset.FeesThrough = calendar.TradingDate(time.Now()).AddDate(0, 0, -1).Format(CALENDAR_DATE_FORMAT)
+		if settings.Margin {
+			asset.Margin = true
+			entry.Margin = true
+			entry.Amount = cost
+		}
+	}
+
+	u.Portfolio = append(u.Portfolio, asset)
+	u.Funds = u.Funds + entry.Amount
+	u.record(entry)
+
+	return asset, nil
+}
+
+// Close up to quantity of the lots matching the type and symbol (and the lot ID or
+// basis, if given) at the specified price, crediting the proceeds and booking gains.
+// Lots are closed in the order of the user's lot relief method, and shares that are
+// reserved for pending orders are left alone. Every lot touched is recorded in the
+// ledger under the given event.
+func (u *User) close(event string, position_type string, symbol string, quantity float64, lot string, basis float64, price float64, session string) (closed []LotShares, funds float64, gains float64, err error) {
+	if u.lotMethod() == LotsAverage {
+		u.averageLots(position_type, symbol, session)
+	}
+
+	var reserved float64
+	lots := u.positionLots(position_type, symbol)
+	for i := range lots {
+		asset := lots[i]
+		if (lot != "" && !strings.EqualFold(asset.ID, lot)) || (basis != 0 && basis != asset.CostBasis) {
+			continue
+		}
+
+		reserved = reserved + asset.Reserved
+		if quantity == 0 || asset.Available() == 0 {
+			continue
+		}
+
+		to_sell := quantity
+		if asset.Available() < to_sell {
+			to_sell = asset.Available()
+		}
+
+		proceeds := asset.CostBasis * to_sell
+		value := price * to_sell
+
+		entry := &LedgerEntry{
+			Event:    event,
+			LotID:    asset.ID,
+			Type:     asset.Type,
+			Symbol:   asset.Symbol,
+			Quantity: to_sell,
+			Price:    price,
+			Basis:    asset.CostBasis,
+			Session:  session,
+		}
+
+		switch position_type {
+		case "long":
+			entry.Amount = value
+			entry.Gain = value - proceeds
+			u.log(map[string]interface{}{
+				"gains": entry.Gain,
+				"value": entry.Amount,
+			}).Info("Closing long position.")
+		case "short":
+			entry.Amount = proceeds + (proceeds - value)
+			entry.Gain = proceeds - value
+			if asset.Margin {
+				entry.Amount = -value
+			}
+			u.log(map[string]interface{}{
+				"gains": entry.Gain,
+				"value": entry.Amount,
+			}).Info("Closing short position.")
+		}
+
+		u.Funds = u.Funds + entry.Amount
+		if entry.Gain != 0 {
+			u.realize(asset.Symbol, entry.Gain, time.Now())
+		}
+		u.record(entry)
+
+		funds = funds + entry.Amount
+		gains = gains + entry.Gain
+		quantity = roundShares(quantity - to_sell)
+		asset.Quantity = roundShares(asset.Quantity - to_sell)
+		closed = append(closed, LotShares{ID: asset.ID, Basis: asset.CostBasis, Quantity: to_sell})
+	}
+
+	if len(closed) == 0 && reserved > 0 {
+		return nil, 0, 0, ErrInsufficientShares
+	} else if len(closed) == 0 {
+		return nil, 0, 0, ErrNoMatchingPosition
+	}
+
+	var portfolio []*Asset
+	for i := range u.Portfolio {
+		if u.Portfolio[i].Quantity > 0 {
+			portfolio = append(portfolio, u.Portfolio[i])
+		}
+	}
+	u.Portfolio = portfolio
+
+	return closed, funds, gains, nil
+}
+
+// Buy or short the shares at the current market price.
+func (u *User) CreatePosition(position_type string, symbol string, quantity float64, source *Command) {
+	user := u
+	quotes.GetQuote(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
 		log := user.log(map[string]interface{}{
-			"method":       "CreatePosition",
-			"type":         position_type,
-			"symbol":       symbol,
-			"quantity":     quantity,
-			"target_price": target,
-			"last_price":   quote.LastPrice,
+			"method":     "CreatePosition",
+			"type":       position_type,
+			"symbol":     symbol,
+			"quantity":   quantity,
+			"last_price": quote.LastPrice,
 		})
 
-		cost_basis := quote.LastPrice
-		if quote.LivePrice != 0.00 && (quote.CurrentSession == "pre_market" || quote.CurrentSession == "post_market") {
-			cost_basis = quote.LivePrice
-		}
-		if strings.HasPrefix(position_type, "limit_") {
-			cost_basis = target
+		side := "buy"
+		if position_type == "short" {
+			side = "short"
 		}
+		cost_basis := marketPrice(side, quote)
 
 		if quote.Symbol != symbol {
 			log.Info("Symbol not found.")
-			source.Say("<@s> I was unable to find that stock; wanna try that again?")
+			source.Say("<@%s> I was unable to find that stock; wanna try that again?", user.UserID)
 			return true
 		}
 
-		if position_type != "limit_sell" {
-			if cost_basis*float64(quantity) > user.Funds {
-				log.WithFields(map[string]interface{}{
-					"cost": cost_basis * float64(quantity),
-				}).Info("Insufficient funds.")
-				source.Say("<@%s>, you don't have enough funds to cover this trade. You have $%.2f available, and at most could do %d shares.", user.UserID, user.Funds, int(math.Floor(user.Funds/cost_basis)))
-				return true
-			}
+		if quote.IsStale() {
+			log.WithField("quote_age", quote.Age()).Warn("Refusing to trade on a stale quote.")
+			source.Say("<@%s>, %s", user.UserID, quote.StaleMessage(symbol))
+			return true
 		}
 
-		asset := &Asset{
-			Type:      position_type,
-			Symbol:    quote.Symbol,
-			CostBasis: cost_basis,
-			Quantity:  int(quantity),
-		}
+		var available float64
+		err := user.Update(func(user *User) (err error) {
+			available = user.available()
+			_, err = user.open(position_type, quote.Symbol, quantity, cost_basis, quote.CurrentSession)
+			return err
+		})
 
-		user.Portfolio = append(user.Portfolio, asset)
-		if position_type != "limit_sell" {
-			user.Funds = user.Funds - (cost_basis * float64(quantity))
+		if err == ErrInsufficientFunds {
+			log.WithFields(map[string]interface{}{
+				"cost": cost_basis * quantity,
+			}).Info("Insufficient funds.")
+			source.Say("<@%s>, you don't have enough funds to cover this trade. You have $%.2f available, and at most could do %s shares.", user.UserID, available, formatShares(floorShares(available/cost_basis)))
+			return true
+		} else if err != nil {
+			log.WithError(err).Error("Unable to save position.")
+			source.Say("<@%s>, something went wrong placing that trade; nothing was changed. Wanna try that again?", user.UserID)
+			return true
 		}
 
 		var action string
@@ -125,207 +408,90 @@ func (u *User) CreatePosition(position_type string, symbol string, quantity int6
 		case "short":
 			log.Info("Shorted shares.")
 			action = "shorted"
-		case "limit_buy":
-			log.Info("Created a limit buy order.")
-			action = "created a limit order to buy"
-			user.HeldFunds = user.HeldFunds + (cost_basis * float64(quantity))
-			user.WatchLimitOrder(asset, source)
-		case "limit_sell":
-			log.Info("Created a limit sell order.")
-			action = "created a limit order to sell"
-			user.WatchLimitOrder(asset, source)
-		case "limit_cover":
-			log.Info("Created a limit cover order.")
-			action = "created a limit order to cover"
-			user.HeldFunds = user.HeldFunds + (cost_basis * float64(quantity))
-			user.WatchLimitOrder(asset, source)
 		}
 
-		user.Save()
-
-		source.Say("<@%s> %s %d shares of %s at $%.2f, totalling $%.2f. They have $%.2f funds remaining.", user.UserID, action, quantity, symbol, cost_basis, cost_basis*float64(quantity), user.Funds)
+		source.Say("<@%s> %s %s shares of %s at $%.2f, totalling $%.2f. They have $%.2f funds remaining.", user.UserID, action, formatShares(quantity), symbol, cost_basis, cost_basis*quantity, user.Funds)
+		user.WatchMargin(symbol)
 		return true
 	})
 }
 
-func (u *User) ClosePosition(position_type string, symbol string, quantity int64, basis float64, source *Command) {
-	user := u
-	tradingview.GetQuote(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
-		user = GetUserByID(user.UserID)
+func (u *User) ClosePosition(position_type string, symbol string, quantity float64, lot string, basis float64, source *Command) {
+	u.closePosition("", position_type, symbol, quantity, lot, basis, source)
+}
+
+// Close the position, recording the closed lots in the ledger under the given event.
+// An empty event records the natural counterpart of the position type; a sell for
+// longs and a cover for shorts.
+func (u *User) closePosition(event string, position_type string, symbol string, quantity float64, lot string, basis float64, source *Command) {
+	if event == "" {
+		switch position_type {
+		case "long":
+			event = LedgerSell
+		case "short":
+			event = LedgerCover
+		}
+	}
 
+	user := u
+	quotes.GetQuote(symbol, func(quote TradingViewQuote) (shouldDelete bool) {
 		log := user.log(map[string]interface{}{
 			"method":     "ClosePosition",
 			"type":       position_type,
 			"symbol":     symbol,
 			"quantity":   quantity,
+			"lot":        lot,
 			"basis":      basis,
 			"last_price": quote.LastPrice,
 		})
 
 		if quote.Symbol != symbol {
 			log.Info("Symbol not found.")
-			source.Say("<@s> I was unable to find that stock; wanna try that again?")
+			source.Say("<@%s> I was unable to find that stock; wanna try that again?", user.UserID)
 			return true
 		}
 
-		cost_basis := quote.LastPrice
-		if quote.LivePrice != 0.00 && (quote.CurrentSession == "pre_market" || quote.CurrentSession == "post_market") {
-			cost_basis = quote.LivePrice
+		if quote.IsStale() {
+			log.WithField("quote_age", quote.Age()).Warn("Refusing to trade on a stale quote.")
+			source.Say("<@%s>, %s", user.UserID, quote.StaleMessage(symbol))
+			return true
 		}
 
-		var gains float64
-		var funds float64
-		var sold int
-
-		var new_portfolio []*Asset
-		for i := range user.Portfolio {
-			asset := user.Portfolio[i]
-			if asset.Symbol == symbol && asset.Type == position_type {
-				if (basis != 0 && basis == asset.CostBasis) || basis == 0 {
-					to_sell := math.Min(float64(quantity), float64(asset.Quantity))
-
-					proceeds := asset.CostBasis * float64(to_sell)
-					value := cost_basis * float64(to_sell)
-
-					switch position_type {
-					case "long":
-						gains = gains + (value - proceeds)
-						funds = funds + value
-						log.WithFields(map[string]interface{}{
-							"gains": (value - proceeds),
-							"value": +value,
-						}).Info("Closing long position.")
-					case "short":
-						gains = gains + (proceeds - value)
-						funds = funds + proceeds + gains
-						log.WithFields(map[string]interface{}{
-							"gains": (proceeds - value),
-							"value": +(proceeds + gains),
-						}).Info("Closing short position.")
-					case "limit_buy":
-						refund := float64(to_sell) * asset.CostBasis
-						user.HeldFunds = user.HeldFunds - refund
-						user.Funds = user.Funds + refund
-						log.WithFields(map[string]interface{}{
-							"refund": refund,
-						}).Info("Closing limit buy position.")
-					case "limit_sell":
-						log.Info("Closing limit sell position.")
-					case "limit_cover":
-						refund := float64(to_sell) * asset.CostBasis
-						user.HeldFunds = user.HeldFunds - refund
-						user.Funds = user.Funds + refund
-						log.WithFields(map[string]interface{}{
-							"refund": refund,
-						}).Info("Closing limit cover position.")
-					}
-
-					quantity = quantity - int64(to_sell)
-					asset.Quantity = asset.Quantity - int(to_sell)
-					sold = sold + int(to_sell)
-				}
-			}
-
-			if asset.Quantity > 0 {
-				new_portfolio = append(new_portfolio, asset)
-			}
+		side := "sell"
+		if position_type == "short" {
+			side = "cover"
 		}
+		cost_basis := marketPrice(side, quote)
 
-		if sold == 0 {
+		var closed []LotShares
+		var funds float64
+		var gains float64
+		err := user.Update(func(user *User) (err error) {
+			closed, funds, gains, err = user.close(event, position_type, symbol, quantity, lot, basis, cost_basis, quote.CurrentSession)
+			return err
+		})
+
+		if err == ErrNoMatchingPosition {
 			source.Say("<@%s>, you don't have those shares, are you trying to pull something?", user.UserID)
 			return true
+		} else if err == ErrInsufficientShares {
+			source.Say("<@%s>, those shares are reserved for your pending orders; cancel or amend them first if you want to close them yourself.", user.UserID)
+			return true
+		} else if err != nil {
+			log.WithError(err).Error("Unable to close position.")
+			source.Say("<@%s>, something went wrong closing that position; nothing was changed. Wanna try that again?", user.UserID)
+			return true
 		}
 
-		user.Funds = user.Funds + funds
-		user.Portfolio = new_portfolio
-		user.Save()
-
 		var description string
 		switch position_type {
 		case "long":
 			description = " sold"
 		case "short":
 			description = " covered"
-		case "limit_buy":
-			return true
-		case "limit_sell":
-			return true
-		case "limit_cover":
-			return true
 		}
 
-		source.Say("<@%s>%s %d shares of %s at $%.2f, totalling $%.2f, netting them $%.2f. They have $%.2f funds remaining.", user.UserID, description, sold, symbol, cost_basis, funds, gains, user.Funds)
+		source.Say("<@%s>%s %s shares of %s from %s at $%.2f, totalling $%.2f, netting them $%.2f. They have $%.2f funds remaining.", user.UserID, description, formatShares(sharesIn(closed)), symbol, describeLots(closed), cost_basis, funds, gains, user.Funds)
 		return true
 	})
 }
-
-func (u *User) WatchLimitOrder(order *Asset, source *Command) {
-	user := u
-	user.log(map[string]interface{}{
-		"method":       "WatchLimitOrder:OnUpdate",
-		"type":         order.Type,
-		"symbol":       order.Symbol,
-		"quantity":     order.Quantity,
-		"target_price": order.CostBasis,
-	}).Info("Creating a new watch limit order job.")
-
-	tradingview.OnUpdate(order.Symbol, func(quote TradingViewQuote) (shouldDelete bool) {
-		user = GetUserByID(user.UserID)
-
-		log := user.log(map[string]interface{}{
-			"method":       "WatchLimitOrder:OnUpdate",
-			"type":         order.Type,
-			"symbol":       order.Symbol,
-			"quantity":     order.Quantity,
-			"target_price": order.CostBasis,
-			"last_price":   quote.LastPrice,
-		})
-
-		cost_basis := quote.LastPrice
-		if quote.LivePrice != 0.00 && (quote.CurrentSession == "pre_market" || quote.CurrentSession == "post_market") {
-			cost_basis = quote.LivePrice
-		}
-
-		asset_found := false
-		for i := range user.Portfolio {
-			asset := user.Portfolio[i]
-
-			if asset.Type == order.Type && asset.Symbol == order.Symbol && asset.Quantity == order.Quantity && asset.CostBasis == order.CostBasis {
-				asset_found = true
-				switch asset.Type {
-				case "limit_buy":
-					if cost_basis <= order.CostBasis {
-						log.Info("Limit buy has been met; closing original position, and creating long.")
-						user.ClosePosition(asset.Type, order.Symbol, int64(order.Quantity), order.CostBasis, source)
-						user.CreatePosition("long", order.Symbol, int64(order.Quantity), 0.0, source)
-						source.Say("<@%s>'s limit buy has been completed.", user.UserID)
-						return true
-					}
-				case "limit_sell":
-					if cost_basis >= order.CostBasis {
-						log.Info("Limit sell has been met; closing original position, and creating long.")
-						user.ClosePosition(order.Type, order.Symbol, int64(order.Quantity), order.CostBasis, source)
-						user.ClosePosition("long", order.Symbol, int64(order.Quantity), 0.0, source)
-						source.Say("<@%s>'s limit sell has been completed.", user.UserID)
-						return true
-					}
-				case "limit_cover":
-					if cost_basis <= order.CostBasis {
-						log.Info("Limit cover has been met; closing original position, and creating long.")
-						user.ClosePosition(order.Type, order.Symbol, int64(order.Quantity), order.CostBasis, source)
-						user.ClosePosition("short", order.Symbol, int64(order.Quantity), 0.0, source)
-						source.Say("<@%s>'s limit cover has been completed.", user.UserID)
-						return true
-					}
-				}
-			}
-		}
-
-		log.WithFields(map[string]interface{}{
-			"asset_found":    asset_found,
-			"deleting_watch": !asset_found,
-		}).Info("No criteria met to action on watch order.")
-
-		return !asset_found
-	})
-}